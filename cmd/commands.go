@@ -0,0 +1,259 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Subcommand of CLI
+type Command struct {
+	Name string
+	// Arguments in usage line, for example "<version>"
+	Args  string
+	Usage string
+	// Names of flags which belong only to the command
+	Flags []string
+	// Command works with database and accepts connection flags
+	Connect bool
+}
+
+func (c *CmdMigrator) Commands() []Command {
+	return []Command{
+		{COMMAND_UP, "[N]", "Apply all pending migrations or the next N", []string{"dry-run", "sql", "strict"}, true},
+		{COMMAND_DOWN, "[N]", "Revert all applied migrations or the last N", []string{"dry-run", "sql", "strict"}, true},
+		{COMMAND_GOTO, "<version>", "Apply or revert migrations to switch to the version", []string{"dry-run", "sql", "strict"}, true},
+		{COMMAND_STATUS, "", "Print current version and state of every migration", []string{"format"}, true},
+		{COMMAND_VERSION, "", "Print current version of database", nil, true},
+		{COMMAND_CREATE, "<name>", "Create empty up and down files with the next version in source folder", []string{"timestamp"}, false},
+		{COMMAND_VALIDATE, "", "Check migration files and that applied ones were not changed. Only files are checked without 'db' and 'url' flags", nil, true},
+		{COMMAND_FORCE, "<version>", "Set version and clear dirty state without running migrations", nil, true},
+		{COMMAND_HELP, "[command]", "Print help of command", nil, false},
+	}
+}
+
+// Flags of actions which are replaced by commands
+var deprecatedFlags = []string{"up", "down", "v", "validate", "force"}
+
+func (c *CmdMigrator) findCommand(name string) (Command, bool) {
+	for _, cmd := range c.Commands() {
+		if cmd.Name == name {
+			return cmd, true
+		}
+	}
+	return Command{}, false
+}
+
+// Flags which are accepted by every command working with database
+func (c *CmdMigrator) isConnectionFlag(name string) bool {
+	if slices.Contains(deprecatedFlags, name) {
+		return false
+	}
+	for _, cmd := range c.Commands() {
+		if slices.Contains(cmd.Flags, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// Register flags with names accepted by include. Current values are used
+// as defaults, so values which are set before parsing are kept.
+func (c *CmdMigrator) registerFlags(fs *flag.FlagSet, include func(name string) bool) {
+	for _, f := range c.StringFlags() {
+		if include(f.Name) {
+			fs.StringVar(f.Pointer, f.Name, *f.Pointer, f.Usage)
+		}
+	}
+	for _, f := range c.BoolFlags() {
+		if include(f.Name) {
+			fs.BoolVar(f.Pointer, f.Name, *f.Pointer, f.Usage)
+		}
+	}
+	for _, f := range c.IntFlags() {
+		if include(f.Name) {
+			fs.IntVar(f.Pointer, f.Name, *f.Pointer, f.Usage)
+		}
+	}
+	for _, f := range c.Int64Flags() {
+		if include(f.Name) {
+			fs.Int64Var(f.Pointer, f.Name, *f.Pointer, f.Usage)
+		}
+	}
+}
+
+// Flags of command: its own flags, source and connection flags if it works with database
+func (c *CmdMigrator) commandFlagSet(cmd Command, errorHandling flag.ErrorHandling) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.Name, errorHandling)
+	c.registerFlags(fs, func(name string) bool {
+		if slices.Contains(cmd.Flags, name) || name == "source" {
+			return true
+		}
+		return cmd.Connect && c.isConnectionFlag(name)
+	})
+	fs.Usage = func() {
+		c.printCommandUsage(fs.Output(), cmd)
+	}
+	return fs
+}
+
+// Parse arguments of command. Flags are allowed after arguments: pms create add_index -timestamp
+func (c *CmdMigrator) parseCommand(cmd Command, arguments []string) error {
+	fs := c.commandFlagSet(cmd, flag.ExitOnError)
+	fs.SetOutput(c.errOutput())
+	if err := fs.Parse(arguments); err != nil {
+		return err
+	}
+	for fs.NArg() > 0 {
+		c.args = append(c.args, fs.Arg(0))
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return err
+		}
+	}
+	fs.Visit(c.markSet)
+	return nil
+}
+
+func (c *CmdMigrator) printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: pms [flags] <command> [arguments] [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range c.Commands() {
+		fmt.Fprintf(w, "  %-20s %s\n", strings.TrimSpace(cmd.Name+" "+cmd.Args), cmd.Usage)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'pms help <command>' for flags of command.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Connection flags:")
+	fs := flag.NewFlagSet("pms", flag.ContinueOnError)
+	fs.SetOutput(w)
+	c.registerFlags(fs, c.isConnectionFlag)
+	fs.PrintDefaults()
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Flags -%s are deprecated, use commands instead.\n", strings.Join(deprecatedFlags, ", -"))
+}
+
+func (c *CmdMigrator) printCommandUsage(w io.Writer, cmd Command) {
+	fmt.Fprintf(w, "Usage: pms %s [flags]\n", strings.TrimSpace(cmd.Name+" "+cmd.Args))
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, cmd.Usage)
+	fs := c.commandFlagSet(cmd, flag.ContinueOnError)
+	fs.SetOutput(w)
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Flags:")
+		fs.PrintDefaults()
+	}
+}
+
+// Print help of command from arguments or usage of CLI
+func (c *CmdMigrator) help() error {
+	if len(c.args) == 0 {
+		c.printUsage(c.output())
+		return nil
+	}
+	cmd, ok := c.findCommand(c.args[0])
+	if !ok {
+		return fmt.Errorf(ERROR_UNKNOWN_COMMAND, c.args[0])
+	}
+	c.printCommandUsage(c.output(), cmd)
+	return nil
+}
+
+// Command and its arguments. Command is selected by deprecated
+// action flags if it isn't provided.
+func (c *CmdMigrator) resolveCommand() (string, []string, error) {
+	var (
+		flags   []string
+		command string
+		args    []string
+	)
+	if c.up {
+		flags = append(flags, "-up")
+		command = COMMAND_UP
+	}
+	if c.down {
+		flags = append(flags, "-down")
+		command = COMMAND_DOWN
+	}
+	if c.version != DEFAULT_VERSION {
+		flags = append(flags, "-v")
+		command, args = COMMAND_GOTO, []string{strconv.FormatInt(c.version, 10)}
+	}
+	if c.validate {
+		flags = append(flags, "-validate")
+		command = COMMAND_VALIDATE
+	}
+	if c.force != DEFAULT_VERSION {
+		flags = append(flags, "-force")
+		command, args = COMMAND_FORCE, []string{strconv.FormatInt(c.force, 10)}
+	}
+
+	switch {
+	case len(flags) == 0:
+		if c.command == "" {
+			return "", nil, fmt.Errorf(ERROR_NOT_PROVIDED_ACTION)
+		}
+		return c.command, c.args, nil
+	case c.command != "":
+		return "", nil, fmt.Errorf(ERROR_FLAG_WITH_COMMAND, flags[0], c.command)
+	case len(flags) > 1:
+		return "", nil, fmt.Errorf(ERROR_FLAGS_CONFLICT, strings.Join(flags, "', '"))
+	}
+
+	fmt.Fprintf(c.errOutput(), WARNING_DEPRECATED_FLAG, flags[0], strings.Join(append([]string{command}, args...), " "))
+	return command, args, nil
+}
+
+// Check number of arguments of command
+func checkArgs(command string, args []string) error {
+	switch command {
+	case COMMAND_CREATE, COMMAND_HELP:
+		return nil
+	case COMMAND_GOTO, COMMAND_FORCE:
+		_, err := versionArg(command, args)
+		return err
+	case COMMAND_UP, COMMAND_DOWN:
+		_, err := stepsArg(command, args)
+		return err
+	default:
+		if len(args) != 0 {
+			return fmt.Errorf(ERROR_UNEXPECTED_ARGS, command)
+		}
+		return nil
+	}
+}
+
+// Version from the argument of 'goto' and 'force' commands
+func versionArg(command string, args []string) (int64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf(ERROR_VERSION_REQUIRED, command)
+	}
+	version, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf(ERROR_VERSION_REQUIRED, command)
+	}
+	return version, nil
+}
+
+// Number of migrations from the optional argument of 'up' and 'down'
+// commands. Returns 0 if argument isn't provided.
+func stepsArg(command string, args []string) (int, error) {
+	switch len(args) {
+	case 0:
+		return 0, nil
+	case 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf(ERROR_STEPS_REQUIRED, command)
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf(ERROR_STEPS_REQUIRED, command)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Prefix of environment variables with settings: PMS_HOST, PMS_SSL_MODE
+	ENV_PREFIX = "PMS_"
+	// Key of config file with named environments
+	CONFIG_ENVIRONMENTS = "environments"
+
+	ERROR_CONFIG_FORMAT       = "error: unknown format of config file %q, use '.yaml', '.yml' or '.toml'"
+	ERROR_CONFIG_READ         = "error: cannot read config file %q: %w"
+	ERROR_CONFIG_SETTING      = "error: invalid setting %q in %s: %w"
+	ERROR_UNKNOWN_ENVIRONMENT = "error: environment %q not found in config file %q"
+	ERROR_ENV_WITHOUT_CONFIG  = "error: environment %q is set, but config file not found"
+	ERROR_PASS_CONFLICT       = "error: 'pass' and 'passFile' can't be used together in %s"
+	ERROR_PASS_FILE           = "error: cannot read password file: %w"
+)
+
+// Config files which are looked up in current folder if 'config' flag isn't set
+var defaultConfigFiles = []string{"pms.yaml", "pms.yml", "pms.toml"}
+
+// Settings of config file: values of flags by their names
+// and the same settings of every named environment.
+type config struct {
+	settings     map[string]any
+	environments map[string]map[string]any
+}
+
+func (c *CmdMigrator) markSet(f *flag.Flag) {
+	if c.setFlags == nil {
+		c.setFlags = make(map[string]bool)
+	}
+	c.setFlags[f.Name] = true
+}
+
+// Load settings from config file, PMS_* environment variables and
+// password file. Call it after GetFlags.
+//
+// Settings are applied from lowest to highest priority:
+//  1. defaults of flags
+//  2. top level settings of config file
+//  3. settings of environment selected by 'env' flag
+//  4. environment variables
+//  5. flags of command line
+//
+// Password is read from 'passFile' unless 'pass' is set with higher priority.
+func (c *CmdMigrator) LoadConfig() error {
+	if c.setFlags["pass"] && c.setFlags["passFile"] {
+		return fmt.Errorf(ERROR_PASS_CONFLICT, "command line")
+	}
+
+	env := c.environmentVariables()
+	if !c.setFlags["config"] && env["config"] != "" {
+		c.config = env["config"]
+	}
+	if !c.setFlags["env"] && env["env"] != "" {
+		c.env = env["env"]
+	}
+
+	path := c.config
+	if path == "" {
+		path = findConfig()
+	}
+	if path != "" {
+		cfg, err := readConfig(path)
+		if err != nil {
+			return err
+		}
+		if err := c.applySettings(cfg.settings, path); err != nil {
+			return err
+		}
+		if c.env != "" {
+			settings, ok := cfg.environments[c.env]
+			if !ok {
+				return fmt.Errorf(ERROR_UNKNOWN_ENVIRONMENT, c.env, path)
+			}
+			if err := c.applySettings(settings, fmt.Sprintf("environment %q of %s", c.env, path)); err != nil {
+				return err
+			}
+		}
+	} else if c.env != "" {
+		return fmt.Errorf(ERROR_ENV_WITHOUT_CONFIG, c.env)
+	}
+
+	settings := make(map[string]any, len(env))
+	for name, value := range env {
+		if c.isSetting(name) {
+			settings[name] = value
+		}
+	}
+	if err := c.applySettings(settings, "environment variables"); err != nil {
+		return err
+	}
+
+	if c.setFlags["pass"] {
+		c.passFile = ""
+	}
+	if c.passFile == "" {
+		return nil
+	}
+	pass, err := os.ReadFile(c.passFile)
+	if err != nil {
+		return fmt.Errorf(ERROR_PASS_FILE, err)
+	}
+	c.pass = strings.TrimRight(string(pass), "\r\n")
+	return nil
+}
+
+// Set flags from settings which aren't set in command line. Password file
+// of lower priority is dropped if settings have password.
+func (c *CmdMigrator) applySettings(settings map[string]any, source string) error {
+	_, hasPass := settings["pass"]
+	_, hasPassFile := settings["passFile"]
+	if hasPass && hasPassFile {
+		return fmt.Errorf(ERROR_PASS_CONFLICT, source)
+	}
+
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	c.registerFlags(fs, c.isSetting)
+	for name, value := range settings {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf(ERROR_CONFIG_SETTING, name, source, errors.New("unknown setting"))
+		}
+		if c.setFlags[name] {
+			continue
+		}
+		switch value.(type) {
+		case map[string]any, []any:
+			return fmt.Errorf(ERROR_CONFIG_SETTING, name, source, errors.New("value should be string, number or boolean"))
+		}
+		if err := fs.Set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf(ERROR_CONFIG_SETTING, name, source, err)
+		}
+	}
+	if hasPass && !c.setFlags["passFile"] {
+		c.passFile = ""
+	}
+	return nil
+}
+
+// Flags which can be set by config file and environment variables
+func (c *CmdMigrator) isSetting(name string) bool {
+	return c.isConnectionFlag(name) && name != "config" && name != "env"
+}
+
+// Values of PMS_* environment variables by names of flags
+func (c *CmdMigrator) environmentVariables() map[string]string {
+	values := make(map[string]string)
+	for _, name := range c.settingNames() {
+		if value, ok := os.LookupEnv(envName(name)); ok {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// Names of flags which are settings, including 'config' and 'env'
+func (c *CmdMigrator) settingNames() []string {
+	var names []string
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	c.registerFlags(fs, c.isConnectionFlag)
+	fs.VisitAll(func(f *flag.Flag) {
+		names = append(names, f.Name)
+	})
+	return names
+}
+
+// Name of environment variable of flag: sslMode is PMS_SSL_MODE
+func envName(flagName string) string {
+	var s strings.Builder
+	s.WriteString(ENV_PREFIX)
+	for i, r := range flagName {
+		if unicode.IsUpper(r) && i > 0 {
+			s.WriteRune('_')
+		}
+		if r == '-' {
+			r = '_'
+		}
+		s.WriteRune(unicode.ToUpper(r))
+	}
+	return s.String()
+}
+
+// First of default config files which exists in current folder
+func findConfig() string {
+	for _, name := range defaultConfigFiles {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+func readConfig(path string) (*config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf(ERROR_CONFIG_READ, path, err)
+	}
+
+	settings := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &settings)
+	case ".toml":
+		err = toml.Unmarshal(content, &settings)
+	default:
+		return nil, fmt.Errorf(ERROR_CONFIG_FORMAT, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf(ERROR_CONFIG_READ, path, err)
+	}
+
+	cfg := &config{settings: settings, environments: make(map[string]map[string]any)}
+	environments, ok := settings[CONFIG_ENVIRONMENTS]
+	if !ok {
+		return cfg, nil
+	}
+	delete(settings, CONFIG_ENVIRONMENTS)
+	byName, ok := environments.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf(ERROR_CONFIG_READ, path, errors.New("'environments' should be a map of environment names to settings"))
+	}
+	for name, env := range byName {
+		envSettings, ok := env.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf(ERROR_CONFIG_READ, path, fmt.Errorf("settings of environment %q should be a map", name))
+		}
+		cfg.environments[name] = envSettings
+	}
+	return cfg, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testYAMLConfig = `
+driver: postgres
+source: db/migrations
+port: 5433
+environments:
+  dev:
+    db: app_dev
+    pass: dev_pass
+  prod:
+    host: db.internal
+    db: app
+    user: deploy
+    outOfOrder: true
+`
+
+const testTOMLConfig = `
+driver = "postgres"
+port = 5433
+
+[environments.prod]
+host = "db.internal"
+db = "app"
+lockTimeout = 60
+`
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("yaml environment", func(t *testing.T) {
+		m := New(nil)
+		m.config = writeConfig(t, "pms.yaml", testYAMLConfig)
+		m.env = "prod"
+		if err := m.LoadConfig(); err != nil {
+			t.Fatal(err)
+		}
+		if m.driver != DRIVER_POSTGRES || m.source != "db/migrations" || m.port != 5433 {
+			t.Errorf("top level settings are not applied: %+v", m)
+		}
+		if m.host != "db.internal" || m.db != "app" || m.user != "deploy" || !m.outOfOrder {
+			t.Errorf("settings of environment are not applied: %+v", m)
+		}
+	})
+
+	t.Run("toml environment", func(t *testing.T) {
+		m := New(nil)
+		m.config = writeConfig(t, "pms.toml", testTOMLConfig)
+		m.env = "prod"
+		if err := m.LoadConfig(); err != nil {
+			t.Fatal(err)
+		}
+		if m.driver != DRIVER_POSTGRES || m.port != 5433 || m.host != "db.internal" || m.db != "app" || m.lockTimeout != 60 {
+			t.Errorf("settings are not applied: %+v", m)
+		}
+	})
+
+	t.Run("precedence", func(t *testing.T) {
+		t.Setenv("PMS_CONFIG", writeConfig(t, "pms.yml", testYAMLConfig))
+		t.Setenv("PMS_ENV", "dev")
+		t.Setenv("PMS_DB", "app_env")
+		t.Setenv("PMS_SSL_MODE", "require")
+		t.Setenv("PMS_PORT", "5434")
+
+		m := New(nil)
+		m.port = 6000
+		m.markSet(&flag.Flag{Name: "port"})
+		if err := m.LoadConfig(); err != nil {
+			t.Fatal(err)
+		}
+		if m.db != "app_env" {
+			t.Errorf("got db %q, expected value of environment variable", m.db)
+		}
+		if m.sslMode != "require" {
+			t.Errorf("got sslMode %q, expected value of environment variable", m.sslMode)
+		}
+		if m.port != 6000 {
+			t.Errorf("got port %d, expected value of command line flag", m.port)
+		}
+		if m.pass != "dev_pass" || m.driver != DRIVER_POSTGRES {
+			t.Errorf("settings of config file are not applied: %+v", m)
+		}
+	})
+
+	t.Run("password file", func(t *testing.T) {
+		passFile := writeConfig(t, "password", "secret\n")
+		t.Setenv("PMS_PASS_FILE", passFile)
+
+		m := New(nil)
+		m.config = writeConfig(t, "pms.yaml", testYAMLConfig)
+		m.env = "dev"
+		if err := m.LoadConfig(); err != nil {
+			t.Fatal(err)
+		}
+		if m.pass != "secret" {
+			t.Errorf("got password %q, expected content of password file", m.pass)
+		}
+
+		m = New(nil)
+		m.pass = "flag_pass"
+		m.markSet(&flag.Flag{Name: "pass"})
+		if err := m.LoadConfig(); err != nil {
+			t.Fatal(err)
+		}
+		if m.pass != "flag_pass" {
+			t.Errorf("got password %q, expected value of command line flag", m.pass)
+		}
+
+		m = New(nil)
+		m.pass = "flag_pass"
+		m.passFile = passFile
+		m.markSet(&flag.Flag{Name: "pass"})
+		m.markSet(&flag.Flag{Name: "passFile"})
+		if err := m.LoadConfig(); err == nil || err.Error() != fmt.Sprintf(ERROR_PASS_CONFLICT, "command line") {
+			t.Errorf("expected error of pass and passFile in command line, got %v", err)
+		}
+	})
+
+	t.Run("default config file", func(t *testing.T) {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "pms.toml"), []byte(testTOMLConfig), 0666)
+		wd, err := os.Getwd()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chdir(dir); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chdir(wd)
+
+		m := New(nil)
+		if err := m.LoadConfig(); err != nil {
+			t.Fatal(err)
+		}
+		if m.port != 5433 {
+			t.Errorf("got port %d, expected value of config file", m.port)
+		}
+	})
+
+	errorTests := []struct {
+		name    string
+		file    string
+		content string
+		env     string
+	}{
+		{"unknown environment", "pms.yaml", testYAMLConfig, "staging"},
+		{"unknown setting", "pms.yaml", "database: app", ""},
+		{"invalid value", "pms.yaml", "port: first", ""},
+		{"nested value", "pms.yaml", "host:\n  name: localhost", ""},
+		{"command flag", "pms.yaml", "dry-run: true", ""},
+		{"pass and passFile", "pms.toml", "pass = \"secret\"\npassFile = \"password\"", ""},
+		{"unknown format", "pms.json", "{}", ""},
+	}
+	for _, test := range errorTests {
+		t.Run(test.name, func(t *testing.T) {
+			m := New(nil)
+			m.config = writeConfig(t, test.file, test.content)
+			m.env = test.env
+			if err := m.LoadConfig(); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"db":          "PMS_DB",
+		"sslMode":     "PMS_SSL_MODE",
+		"lockTimeout": "PMS_LOCK_TIMEOUT",
+		"passFile":    "PMS_PASS_FILE",
+	}
+	for name, expected := range tests {
+		if got := envName(name); got != expected {
+			t.Errorf("got %q for %q, expected %q", got, name, expected)
+		}
+	}
+}
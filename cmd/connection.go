@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	DEFAULT_POSTGRES_PORT = 5432
+	DEFAULT_MYSQL_PORT    = 3306
+
+	ERROR_DRIVER_MISMATCH = "error: driver %q can't be used with %q URL"
+)
+
+// Connection string of the driver from 'url' flag or from host, port, db,
+// user, pass and sslMode flags:
+//
+//	mysql:    user:pass@tcp(host:port)/db?multiStatements=true&tls=...
+//	postgres: host=... port=... dbname=... user=... password=... sslmode=...
+//	sqlite:   path of database file
+//
+// URLs with mysql:// scheme are converted to the format of MySQL driver,
+// sqlite:// prefix is removed, other URLs are used as is.
+func (c *CmdMigrator) MakeConnectionString() string {
+	if c.url != "" {
+		return c.urlConnectionString()
+	}
+	switch c.driver {
+	case DRIVER_SQLITE:
+		return c.db
+	case DRIVER_MYSQL:
+		cfg := mysqlConfig()
+		cfg.User = c.user
+		cfg.Passwd = c.pass
+		cfg.Addr = net.JoinHostPort(c.host, strconv.Itoa(portOr(c.port, DEFAULT_MYSQL_PORT)))
+		cfg.DBName = c.db
+		cfg.TLSConfig = mysqlTLS(c.sslMode)
+		return cfg.FormatDSN()
+	default:
+		return postgresKeywords([][2]string{
+			{"host", c.host},
+			{"port", strconv.Itoa(portOr(c.port, DEFAULT_POSTGRES_PORT))},
+			{"dbname", c.db},
+			{"user", c.user},
+			{"password", c.pass},
+			{"sslmode", c.sslMode},
+		})
+	}
+}
+
+func (c *CmdMigrator) urlConnectionString() string {
+	conn := strings.ToValidUTF8(c.url, "")
+	switch driverOfURL(conn) {
+	case DRIVER_SQLITE:
+		return conn[strings.Index(conn, "://")+len("://"):]
+	case DRIVER_MYSQL:
+		u, err := url.Parse(conn)
+		if err != nil {
+			// reported by driver
+			return conn
+		}
+		cfg := mysqlConfig()
+		cfg.User = u.User.Username()
+		cfg.Passwd, _ = u.User.Password()
+		cfg.Addr = u.Host
+		if u.Port() == "" {
+			cfg.Addr = net.JoinHostPort(u.Hostname(), strconv.Itoa(DEFAULT_MYSQL_PORT))
+		}
+		cfg.DBName = strings.TrimPrefix(u.Path, "/")
+		for name, values := range u.Query() {
+			switch name {
+			case "tls":
+				cfg.TLSConfig = values[0]
+			case "multiStatements":
+				cfg.MultiStatements = values[0] == "true"
+			default:
+				if cfg.Params == nil {
+					cfg.Params = make(map[string]string)
+				}
+				cfg.Params[name] = values[0]
+			}
+		}
+		return cfg.FormatDSN()
+	default:
+		return conn
+	}
+}
+
+// Set driver from the scheme of 'url' flag if driver isn't set
+// explicitly. Returns error if explicit driver doesn't match the URL.
+func (c *CmdMigrator) detectDriver() error {
+	driver := driverOfURL(c.url)
+	if driver == "" || driver == c.driver || (driver == DRIVER_POSTGRES && c.driver == DRIVER_PGX) {
+		return nil
+	}
+	if c.setFlags["driver"] || c.driver != DEFAULT_DRIVER {
+		return fmt.Errorf(ERROR_DRIVER_MISMATCH, c.driver, driver)
+	}
+	c.driver = driver
+	return nil
+}
+
+// Driver of the URL scheme or empty string if it's unknown
+func driverOfURL(conn string) string {
+	scheme, _, ok := strings.Cut(conn, "://")
+	if !ok {
+		return ""
+	}
+	switch strings.ToLower(scheme) {
+	case "postgres", "postgresql":
+		return DRIVER_POSTGRES
+	case "mysql":
+		return DRIVER_MYSQL
+	case "sqlite", "sqlite3":
+		return DRIVER_SQLITE
+	default:
+		return ""
+	}
+}
+
+// Config of MySQL driver. Multiple statements are allowed in one query,
+// for example in a block between pms:statement-begin and pms:statement-end.
+func mysqlConfig() *mysql.Config {
+	cfg := mysql.NewConfig()
+	cfg.Net = "tcp"
+	cfg.MultiStatements = true
+	return cfg
+}
+
+// Value of MySQL 'tls' parameter for Postgres ssl mode. Values
+// of MySQL driver are used as is.
+func mysqlTLS(sslMode string) string {
+	switch sslMode {
+	case "", "disable":
+		return ""
+	case "allow", "prefer":
+		return "preferred"
+	case "require", "verify-ca":
+		return "skip-verify"
+	case "verify-full":
+		return "true"
+	default:
+		return sslMode
+	}
+}
+
+func portOr(port, defaultPort int) int {
+	if port == 0 {
+		return defaultPort
+	}
+	return port
+}
+
+// Keyword/value connection string of Postgres. Values with spaces
+// or quotes are quoted, empty values are skipped.
+func postgresKeywords(params [][2]string) string {
+	var parts []string
+	for _, p := range params {
+		if p[1] == "" {
+			continue
+		}
+		value := p[1]
+		if strings.ContainsAny(value, ` '\`) {
+			value = "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+		}
+		parts = append(parts, p[0]+"="+value)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/Moranilt/pms"
+)
+
+// Exit codes of CLI
+const (
+	EXIT_OK    = 0
+	EXIT_ERROR = 1
+	// Wrong command, arguments or flags. Same code is used by flag package.
+	EXIT_USAGE         = 2
+	EXIT_CONNECTION    = 3
+	EXIT_VALIDATION    = 4
+	EXIT_MIGRATION     = 5
+	EXIT_LOCK_TIMEOUT  = 6
+	EXIT_NOTHING_TO_DO = 7
+)
+
+// Error returned by Run with exit code of the process
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// Wrap err with exit code. Returns nil if err is nil.
+func exitError(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ExitError{Code: code, Err: err}
+}
+
+// Exit code of error returned by createMigrator. Wrong source and options
+// are usage errors, others happen when version tables are prepared.
+func createMigratorExitCode(err error) int {
+	var pathErr *fs.PathError
+	if errors.Is(err, pms.ErrInvalidOption) || errors.As(err, &pathErr) {
+		return EXIT_USAGE
+	}
+	return EXIT_MIGRATION
+}
+
+// Exit code of error returned by Run. Lock timeout and "nothing to do"
+// have their own codes whatever step returned them.
+func ExitCode(err error) int {
+	if err == nil {
+		return EXIT_OK
+	}
+	if errors.Is(err, pms.ErrLockTimeout) {
+		return EXIT_LOCK_TIMEOUT
+	}
+	if errors.Is(err, pms.ErrNoChange) {
+		return EXIT_NOTHING_TO_DO
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	return EXIT_ERROR
+}
@@ -1,48 +1,116 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/Moranilt/pms"
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
 const (
-	DEFAULT_SOURCE   = "migrations"
-	DEFAULT_VERSION  = -1
-	DEFAULT_PORT     = 5432
-	DEFAULT_HOST     = "localhost"
-	DEFAULT_DB       = ""
-	DEFAULT_USER     = "root"
-	DEFAULT_PASS     = ""
-	DEFAULT_SSL_MODE = "disable"
-	DEFAULT_DRIVER   = "mysql"
-	DEFAULT_URL      = ""
+	DEFAULT_SOURCE       = "migrations"
+	DEFAULT_VERSION      = -1
+	DEFAULT_PORT         = 0 // default port of driver
+	DEFAULT_HOST         = "localhost"
+	DEFAULT_DB           = ""
+	DEFAULT_USER         = "root"
+	DEFAULT_PASS         = ""
+	DEFAULT_SSL_MODE     = "disable"
+	DEFAULT_DRIVER       = DRIVER_MYSQL
+	DEFAULT_URL          = ""
+	DEFAULT_LOCK_TIMEOUT = 15 // seconds
+	DEFAULT_FORMAT       = FORMAT_TABLE
+	DEFAULT_TRANSACTION  = string(pms.TRANSACTION_SINGLE)
+	DEFAULT_TABLE        = pms.TABLE_NAME
+	DEFAULT_SCHEMA       = ""
+	DEFAULT_CONFIG       = ""
+	DEFAULT_ENV          = ""
+	DEFAULT_PASS_FILE    = ""
+
+	DRIVER_SQLITE   = "sqlite"
+	DRIVER_MYSQL    = "mysql"
+	DRIVER_POSTGRES = "postgres"
+	DRIVER_PGX      = "pgx"
+
+	COMMAND_UP       = "up"
+	COMMAND_DOWN     = "down"
+	COMMAND_GOTO     = "goto"
+	COMMAND_STATUS   = "status"
+	COMMAND_VERSION  = "version"
+	COMMAND_CREATE   = "create"
+	COMMAND_VALIDATE = "validate"
+	COMMAND_FORCE    = "force"
+	COMMAND_HELP     = "help"
+
+	FORMAT_TABLE = "table"
+	FORMAT_JSON  = "json"
 
 	ERROR_DB_REQUIRED         = "error: 'url' or 'db' flag required"
-	ERROR_NOT_PROVIDED_ACTION = "error: provide 'up', 'down' or 'version' flag"
+	ERROR_NOT_PROVIDED_ACTION = "error: provide command: 'up', 'down', 'goto', 'status', 'version', 'create', 'validate' or 'force'. Run 'pms help' for usage"
+	ERROR_UNKNOWN_COMMAND     = "error: unknown command %q"
+	ERROR_NAME_REQUIRED       = "error: provide name of migration: 'create <name>'"
+	ERROR_VERSION_REQUIRED    = "error: provide version: '%s <version>'"
+	ERROR_UNEXPECTED_ARGS     = "error: '%s' command takes no arguments"
+	ERROR_STEPS_REQUIRED      = "error: number of migrations should be a positive integer: '%s [N]'"
+	ERROR_UNKNOWN_FORMAT      = "error: unknown format %q, use 'table' or 'json'"
+	ERROR_DRY_RUN_ACTION      = "error: 'dry-run' works only with 'up', 'down' or 'goto' command"
+	ERROR_FLAGS_CONFLICT      = "error: flags '%s' can't be used together"
+	ERROR_FLAG_WITH_COMMAND   = "error: flag '%s' can't be used with %q command"
+	ERROR_CONNECTION          = "error: cannot connect to database: %w"
+	ERROR_NOTHING_TO_DO       = "%w: nothing to migrate, version %d"
+
+	WARNING_DEPRECATED_FLAG = "warning: flag '%s' is deprecated, use 'pms %s' command\n"
 )
 
-type CreateMigrator = func(db pms.DB, path string) (pms.Migrator, error)
+type CreateMigrator = func(db pms.DB, path string, opts ...pms.Option) (pms.Migrator, error)
 type CmdMigrator struct {
-	source         string
-	up             bool
-	down           bool
-	host           string
-	port           int
-	db             string
-	user           string
-	pass           string
-	version        int
-	sslMode        string
-	driver         string
-	url            string
+	source      string
+	up          bool
+	down        bool
+	validate    bool
+	dryRun      bool
+	strict      bool
+	outOfOrder  bool
+	printSQL    bool
+	host        string
+	port        int
+	db          string
+	user        string
+	pass        string
+	version     int64
+	force       int64
+	sslMode     string
+	driver      string
+	url         string
+	lockTimeout int
+	command     string
+	args        []string
+	format      string
+	transaction string
+	table       string
+	schema      string
+	config      string
+	env         string
+	passFile    string
+	timestamp   bool
+	// Names of flags set in command line. They have priority over config and environment variables.
+	setFlags       map[string]bool
 	createMigrator CreateMigrator
+	out            io.Writer
+	errOut         io.Writer
 }
 
 func New(c CreateMigrator) *CmdMigrator {
@@ -55,12 +123,20 @@ func New(c CreateMigrator) *CmdMigrator {
 		db:             DEFAULT_DB,
 		user:           DEFAULT_USER,
 		version:        DEFAULT_VERSION,
+		force:          DEFAULT_VERSION,
 		sslMode:        DEFAULT_SSL_MODE,
 		driver:         DEFAULT_DRIVER,
+		lockTimeout:    DEFAULT_LOCK_TIMEOUT,
+		format:         DEFAULT_FORMAT,
+		transaction:    DEFAULT_TRANSACTION,
+		table:          DEFAULT_TABLE,
+		schema:         DEFAULT_SCHEMA,
+		out:            os.Stdout,
+		errOut:         os.Stderr,
 	}
 }
 
-type FlagType[T int | string | bool] struct {
+type FlagType[T int | int64 | string | bool] struct {
 	Pointer      *T
 	Name         string
 	DefaultValue T
@@ -71,89 +147,308 @@ func (c *CmdMigrator) StringFlags() []FlagType[string] {
 	return []FlagType[string]{
 		{&c.source, "source", DEFAULT_SOURCE, "Source of migration files. For example './migrations'"},
 		{&c.host, "host", DEFAULT_HOST, "Database host"},
-		{&c.db, "db", DEFAULT_DB, "Database name"},
+		{&c.db, "db", DEFAULT_DB, "Database name. Path to database file for 'sqlite' driver"},
 		{&c.user, "user", DEFAULT_USER, "Database user"},
 		{&c.pass, "pass", DEFAULT_PASS, "Database password"},
 		{&c.sslMode, "sslMode", DEFAULT_SSL_MODE, "Set ssl mode"},
-		{&c.driver, "driver", DEFAULT_DRIVER, "Set MySQL driver"},
+		{&c.driver, "driver", DEFAULT_DRIVER, "Database driver: 'mysql', 'postgres' or 'sqlite'"},
 		{&c.url, "url", DEFAULT_URL, "Connection URL"},
+		{&c.transaction, "transaction", DEFAULT_TRANSACTION, "Transaction mode: 'single' for all migrations, 'per-migration' or 'none'"},
+		{&c.format, "format", DEFAULT_FORMAT, "Output format of 'status' command: 'table' or 'json'"},
+		{&c.table, "table", DEFAULT_TABLE, "Name of version table. History table is named '{table}_history'"},
+		{&c.schema, "schema", DEFAULT_SCHEMA, "Schema of version and history tables. Current schema is used by default"},
+		{&c.config, "config", DEFAULT_CONFIG, "Path of config file '.yaml', '.yml' or '.toml'. 'pms.yaml', 'pms.yml' or 'pms.toml' from current folder is used by default"},
+		{&c.env, "env", DEFAULT_ENV, "Name of environment from config file"},
+		{&c.passFile, "passFile", DEFAULT_PASS_FILE, "Path of file with database password"},
 	}
 }
 
 func (c *CmdMigrator) BoolFlags() []FlagType[bool] {
 	return []FlagType[bool]{
-		{&c.up, "up", false, "Run all migrations from provided path"},
-		{&c.down, "down", false, "Run all down migrations from provided path"},
+		{&c.up, "up", false, "Deprecated: use 'up' command"},
+		{&c.down, "down", false, "Deprecated: use 'down' command"},
+		{&c.validate, "validate", false, "Deprecated: use 'validate' command"},
+		{&c.outOfOrder, "outOfOrder", false, "Apply migrations with versions lower than current which are not applied yet"},
+		{&c.dryRun, "dry-run", false, "Print migrations which will run without executing them"},
+		{&c.printSQL, "sql", false, "Print content of files with 'dry-run' flag"},
+		{&c.strict, "strict", false, "Exit with code 7 if there are no migrations to run"},
+		{&c.timestamp, "timestamp", false, "Use current UTC time as version of file in 'create' command instead of sequential number"},
 	}
 }
 
 func (c *CmdMigrator) IntFlags() []FlagType[int] {
 	return []FlagType[int]{
-		{&c.port, "port", DEFAULT_PORT, "Database port"},
-		{&c.version, "v", DEFAULT_VERSION, "Select version of migrations"},
+		{&c.port, "port", DEFAULT_PORT, "Database port. 5432 for 'postgres' and 3306 for 'mysql' driver by default"},
+		{&c.lockTimeout, "lockTimeout", DEFAULT_LOCK_TIMEOUT, "Seconds to wait for the migration lock"},
+	}
+}
+
+func (c *CmdMigrator) Int64Flags() []FlagType[int64] {
+	return []FlagType[int64]{
+		{&c.version, "v", DEFAULT_VERSION, "Deprecated: use 'goto <version>' command"},
+		{&c.force, "force", DEFAULT_VERSION, "Deprecated: use 'force <version>' command"},
 	}
 }
 
+// Parse flags before command, command and its flags and arguments.
+// All flags are accepted before command to keep deprecated flags working.
 func (c *CmdMigrator) GetFlags() {
-	for _, f := range c.StringFlags() {
-		flag.StringVar(f.Pointer, f.Name, f.DefaultValue, f.Usage)
+	c.registerFlags(flag.CommandLine, func(string) bool { return true })
+	flag.Usage = func() {
+		c.printUsage(flag.CommandLine.Output())
+	}
+	flag.Parse()
+	flag.Visit(c.markSet)
+	if flag.NArg() == 0 {
+		return
 	}
 
-	for _, f := range c.BoolFlags() {
-		flag.BoolVar(f.Pointer, f.Name, f.DefaultValue, f.Usage)
+	c.command = flag.Arg(0)
+	cmd, ok := c.findCommand(c.command)
+	if !ok {
+		// reported by Run
+		c.args = flag.Args()[1:]
+		return
 	}
+	c.parseCommand(cmd, flag.Args()[1:])
+}
 
-	for _, f := range c.IntFlags() {
-		flag.IntVar(f.Pointer, f.Name, f.DefaultValue, f.Usage)
+// Options of migrator from flags
+func (c *CmdMigrator) Options() []pms.Option {
+	opts := []pms.Option{
+		pms.WithLockTimeout(time.Duration(c.lockTimeout) * time.Second),
+		pms.WithAllowOutOfOrder(c.outOfOrder),
+		pms.WithLogger(pms.NewTextLogger(c.output())),
 	}
-	flag.Parse()
+	if d := dialectOf(c.driver); d != nil {
+		opts = append(opts, pms.WithDialect(d))
+	}
+	if c.transaction != "" {
+		opts = append(opts, pms.WithTransactionMode(pms.TransactionMode(c.transaction)))
+	}
+	if c.table != "" {
+		opts = append(opts, pms.WithTableName(c.table))
+	}
+	if c.schema != "" {
+		opts = append(opts, pms.WithSchema(c.schema))
+	}
+	if c.dryRun {
+		// plan is printed without creating or changing tables
+		opts = append(opts, pms.WithReadOnly(true))
+	}
+	return opts
 }
 
-func (c *CmdMigrator) MakeConnectionString() string {
-	if c.url != "" {
-		return strings.ToValidUTF8(c.url, "")
+// Dialect of the driver name or nil if it should be detected by pms
+func dialectOf(driver string) pms.Dialect {
+	switch driver {
+	case DRIVER_MYSQL:
+		return &pms.MySQLDialect{}
+	case DRIVER_POSTGRES, DRIVER_PGX:
+		return &pms.PostgresDialect{}
+	case DRIVER_SQLITE:
+		return &pms.SQLiteDialect{}
+	default:
+		return nil
 	}
-	return fmt.Sprintf(
-		"host=%s dbname=%s user=%s password=%s port=%d sslmode=%s",
-		c.host, c.db, c.user, c.pass, c.port, c.sslMode,
-	)
 }
 
 func (c *CmdMigrator) Run(makeConnection func(driver string, conn string) (pms.DB, error)) error {
-	c.db = strings.ToValidUTF8(strings.ReplaceAll(c.db, " ", ""), "")
+	return c.RunContext(context.Background(), makeConnection)
+}
+
+// Same as Run. Running migration is cancelled when ctx is done.
+//
+// Returned error is matched by ExitCode to get exit code of the process.
+func (c *CmdMigrator) RunContext(ctx context.Context, makeConnection func(driver string, conn string) (pms.DB, error)) error {
+	if _, ok := c.findCommand(c.command); !ok && c.command != "" {
+		return exitError(EXIT_USAGE, fmt.Errorf(ERROR_UNKNOWN_COMMAND, c.command))
+	}
+	switch c.command {
+	case COMMAND_CREATE:
+		return c.create()
+	case COMMAND_HELP:
+		return exitError(EXIT_USAGE, c.help())
+	}
+
+	if c.driver == DRIVER_SQLITE {
+		c.db = strings.ToValidUTF8(c.db, "")
+	} else {
+		c.db = strings.ToValidUTF8(strings.ReplaceAll(c.db, " ", ""), "")
+	}
 	if c.url == "" && c.db == "" {
-		return fmt.Errorf(ERROR_DB_REQUIRED)
+		// files can be checked without database
+		if c.command == COMMAND_VALIDATE || (c.command == "" && c.validate) {
+			if _, _, err := c.resolveCommand(); err != nil {
+				return exitError(EXIT_USAGE, err)
+			}
+			return exitError(EXIT_VALIDATION, c.validateSource())
+		}
+		return exitError(EXIT_USAGE, fmt.Errorf(ERROR_DB_REQUIRED))
 	}
 
-	if !c.up && !c.down && c.version == -1 {
-		return fmt.Errorf(ERROR_NOT_PROVIDED_ACTION)
+	command, args, err := c.resolveCommand()
+	if err != nil {
+		return exitError(EXIT_USAGE, err)
+	}
+	if err := checkArgs(command, args); err != nil {
+		return exitError(EXIT_USAGE, err)
+	}
+	if c.dryRun && command != COMMAND_UP && command != COMMAND_DOWN && command != COMMAND_GOTO {
+		return exitError(EXIT_USAGE, fmt.Errorf(ERROR_DRY_RUN_ACTION))
+	}
+	if command == COMMAND_STATUS && c.format != "" && c.format != FORMAT_TABLE && c.format != FORMAT_JSON {
+		return exitError(EXIT_USAGE, fmt.Errorf(ERROR_UNKNOWN_FORMAT, c.format))
 	}
 
+	if err := c.detectDriver(); err != nil {
+		return exitError(EXIT_USAGE, err)
+	}
 	db, err := makeConnection(c.driver, c.MakeConnectionString())
 	if err != nil {
-		return err
+		return exitError(EXIT_CONNECTION, err)
 	}
 	defer db.Close()
+	if err := db.PingContext(ctx); err != nil {
+		return exitError(EXIT_CONNECTION, fmt.Errorf(ERROR_CONNECTION, err))
+	}
 
-	m, err := c.createMigrator(db, c.source)
+	m, err := c.createMigrator(db, c.source, c.Options()...)
 	if err != nil {
-		return err
+		return exitError(createMigratorExitCode(err), err)
 	}
-	if c.up {
-		m.Up()
-		return nil
+
+	switch command {
+	case COMMAND_UP:
+		if n, _ := stepsArg(command, args); n > 0 {
+			return c.steps(ctx, m, n)
+		}
+		return c.migrate(ctx, func() (*pms.Plan, error) { return m.Plan(-1) }, m.UpContext)
+	case COMMAND_DOWN:
+		if n, _ := stepsArg(command, args); n > 0 {
+			return c.steps(ctx, m, -n)
+		}
+		return c.migrate(ctx, func() (*pms.Plan, error) { return m.Plan(0) }, m.DownContext)
+	case COMMAND_GOTO:
+		version, _ := versionArg(command, args)
+		return c.migrate(ctx, func() (*pms.Plan, error) { return m.Plan(version) }, func(ctx context.Context) error {
+			return m.VersionContext(ctx, version)
+		})
+	case COMMAND_STATUS:
+		return c.printStatus(m)
+	case COMMAND_VERSION:
+		return c.printVersion(m)
+	case COMMAND_VALIDATE:
+		return exitError(EXIT_VALIDATION, m.Validate())
+	case COMMAND_FORCE:
+		version, _ := versionArg(command, args)
+		return exitError(EXIT_MIGRATION, m.Force(version))
 	}
-	if c.down {
-		m.Down()
-		return nil
+	return nil
+}
+
+// Apply n migrations if n is positive or revert -n migrations
+func (c *CmdMigrator) steps(ctx context.Context, m pms.Migrator, n int) error {
+	return c.migrate(ctx, func() (*pms.Plan, error) { return m.PlanSteps(n) }, func(ctx context.Context) error {
+		return m.StepsContext(ctx, n)
+	})
+}
+
+// Run migrations or print their plan with 'dry-run' flag. If there are
+// no migrations to run it succeeds, with 'strict' flag returns error
+// matching pms.ErrNoChange.
+func (c *CmdMigrator) migrate(ctx context.Context, plan func() (*pms.Plan, error), run func(context.Context) error) error {
+	p, err := plan()
+	if err != nil {
+		return exitError(EXIT_MIGRATION, err)
+	}
+	if c.dryRun {
+		return p.Write(c.output(), c.printSQL)
+	}
+	if len(p.Migrations) == 0 {
+		if c.strict {
+			return fmt.Errorf(ERROR_NOTHING_TO_DO, pms.ErrNoChange, p.From)
+		}
+		return p.Write(c.output(), false)
 	}
-	if c.version != -1 {
-		m.Version(c.version)
+	err = run(ctx)
+	// applied by another process after plan
+	if errors.Is(err, pms.ErrNoChange) && !c.strict {
 		return nil
 	}
+	return exitError(EXIT_MIGRATION, err)
+}
+
+// Create files of a new migration in source folder
+func (c *CmdMigrator) create() error {
+	if len(c.args) == 0 {
+		return exitError(EXIT_USAGE, fmt.Errorf(ERROR_NAME_REQUIRED))
+	}
+
+	versionType := pms.VERSION_SEQUENTIAL
+	if c.timestamp {
+		versionType = pms.VERSION_TIMESTAMP
+	}
+	files, err := pms.Create(c.source, strings.Join(c.args, "_"), versionType)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		fmt.Fprintln(c.output(), "Created", file)
+	}
+	return nil
+}
+
+// Check migration files in source folder
+func (c *CmdMigrator) validateSource() error {
+	err := pms.ValidateDir(c.source)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.output(), "Directory %q is valid\n", c.source)
 	return nil
 }
 
+// Print state of database and every migration
+func (c *CmdMigrator) printStatus(m pms.Migrator) error {
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+	if c.format == FORMAT_JSON {
+		return status.WriteJSON(c.output())
+	}
+	return status.Write(c.output())
+}
+
+// Print current version of database
+func (c *CmdMigrator) printVersion(m pms.Migrator) error {
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+	if status.Dirty {
+		_, err = fmt.Fprintf(c.output(), "%d (dirty)\n", status.Version)
+		return err
+	}
+	_, err = fmt.Fprintf(c.output(), "%d\n", status.Version)
+	return err
+}
+
+func (c *CmdMigrator) output() io.Writer {
+	if c.out == nil {
+		return os.Stdout
+	}
+	return c.out
+}
+
+func (c *CmdMigrator) errOutput() io.Writer {
+	if c.errOut == nil {
+		return os.Stderr
+	}
+	return c.errOut
+}
+
 func makeConnection(diver string, conn string) (pms.DB, error) {
 	db, err := sql.Open(diver, conn)
 	if err != nil {
@@ -164,10 +459,18 @@ func makeConnection(diver string, conn string) (pms.DB, error) {
 }
 
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	cmd := New(pms.New)
 	cmd.GetFlags()
-	err := cmd.Run(makeConnection)
+	err := exitError(EXIT_USAGE, cmd.LoadConfig())
+	if err == nil {
+		err = cmd.RunContext(ctx, makeConnection)
+	}
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
 	}
+	stop()
+	os.Exit(ExitCode(err))
 }
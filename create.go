@@ -0,0 +1,93 @@
+package pms
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How version of a new migration is picked by Create
+type VersionType string
+
+const (
+	// Greatest version in directory plus one
+	VERSION_SEQUENTIAL VersionType = "sequential"
+	// Current UTC time in format yyyymmddhhmmss
+	VERSION_TIMESTAMP VersionType = "timestamp"
+
+	TIMESTAMP_FORMAT = "20060102150405"
+)
+
+var migrationNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// Create empty `up` and `down` files of a new migration in dir
+// of the OS filesystem and return their paths.
+//
+// Spaces and dashes in name are replaced with underscores.
+// Returns an error if version or name is already used by another file.
+func Create(dir string, name string, versionType VersionType) ([]string, error) {
+	name = strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return r == ' ' || r == '-' || r == '\t'
+	}), "_")
+	if !migrationNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("name of migration %q should contain only letters, digits and underscores", name)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create directory %q: %w", dir, err)
+	}
+	files, err := readDir(os.DirFS(dir), ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var latest int64
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		fileVersion := getVersionFromName(file.Name())
+		if fileVersion > latest {
+			latest = fileVersion
+		}
+		if getNameFromFileName(file.Name()) == name {
+			return nil, fmt.Errorf("migration %q already exists in file %q", name, file.Name())
+		}
+	}
+
+	var version int64
+	switch versionType {
+	case VERSION_SEQUENTIAL:
+		version = latest + 1
+	case VERSION_TIMESTAMP:
+		version, err = strconv.ParseInt(time.Now().UTC().Format(TIMESTAMP_FORMAT), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if version <= latest {
+			return nil, fmt.Errorf("version %d should be greater than the latest version %d", version, latest)
+		}
+	default:
+		return nil, fmt.Errorf("unknown version type %q", versionType)
+	}
+
+	var created []string
+	for _, direction := range []Direction{DIRECTION_UP, DIRECTION_DOWN} {
+		filePath := filepath.Join(dir, fmt.Sprintf("%d_%s.%s.sql", version, name, direction))
+		file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			for _, c := range created {
+				os.Remove(c)
+			}
+			return nil, fmt.Errorf("cannot create file: %w", err)
+		}
+		file.Close()
+		created = append(created, filePath)
+	}
+
+	return created, nil
+}
@@ -0,0 +1,75 @@
+package pms
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCreate(t *testing.T) {
+	t.Run("sequential", func(t *testing.T) {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "1_users.up.sql"), nil, 0644)
+		os.WriteFile(filepath.Join(dir, "1_users.down.sql"), nil, 0644)
+		os.WriteFile(filepath.Join(dir, "12_posts.up.sql"), nil, 0644)
+
+		files, err := Create(dir, "add index", VERSION_SEQUENTIAL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{
+			filepath.Join(dir, "13_add_index.up.sql"),
+			filepath.Join(dir, "13_add_index.down.sql"),
+		}
+		if len(files) != 2 || files[0] != expected[0] || files[1] != expected[1] {
+			t.Fatalf("got %v, expected %v", files, expected)
+		}
+		for _, file := range files {
+			if content, err := os.ReadFile(file); err != nil || len(content) != 0 {
+				t.Errorf("expected empty file %q, got %q, %v", file, content, err)
+			}
+		}
+	})
+
+	t.Run("creates directory", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "migrations")
+		files, err := Create(dir, "users", VERSION_SEQUENTIAL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files[0] != filepath.Join(dir, "1_users.up.sql") {
+			t.Errorf("got %q", files[0])
+		}
+	})
+
+	t.Run("timestamp", func(t *testing.T) {
+		dir := t.TempDir()
+		before, _ := strconv.ParseInt(time.Now().UTC().Format(TIMESTAMP_FORMAT), 10, 64)
+		files, err := Create(dir, "users", VERSION_TIMESTAMP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		version := getVersionFromName(filepath.Base(files[0]))
+		if version < before {
+			t.Errorf("expected version not less than %d, got %d", before, version)
+		}
+	})
+
+	t.Run("duplicate name", func(t *testing.T) {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "1_users.up.sql"), nil, 0644)
+		if _, err := Create(dir, "users", VERSION_SEQUENTIAL); err == nil {
+			t.Error("expected error for duplicated name")
+		}
+	})
+
+	t.Run("invalid name", func(t *testing.T) {
+		for _, name := range []string{"", "users.sql", "../users"} {
+			if _, err := Create(t.TempDir(), name, VERSION_SEQUENTIAL); err == nil {
+				t.Errorf("expected error for name %q", name)
+			}
+		}
+	})
+}
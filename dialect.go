@@ -0,0 +1,131 @@
+package pms
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	SELECT_VERSION         = "SELECT version, dirty FROM %s"
+	SELECT_VERSION_ONLY    = "SELECT version FROM %s"
+	QUERY_UPDATE_VERSION   = "UPDATE %s SET version=%s, dirty=false"
+	QUERY_SET_DIRTY        = "UPDATE %s SET dirty=true"
+	QUERY_ADD_DIRTY_COLUMN = "ALTER TABLE %s ADD COLUMN dirty BOOLEAN NOT NULL DEFAULT FALSE"
+
+	SELECT_HISTORY       = "SELECT version, name, direction, checksum, applied_at FROM %s ORDER BY id"
+	QUERY_INSERT_HISTORY = `INSERT INTO %s
+		(version, name, direction, checksum, applied_at, execution_time_ms, hostname, applied_by)
+		VALUES (%s)`
+)
+
+// SQL which differs between databases.
+//
+// Table names are passed unquoted and may be qualified with schema as
+// `schema.table`, implementation is responsible for quoting them.
+type Dialect interface {
+	// Name of the dialect. For example "postgres"
+	Name() string
+	// Quote identifier such as table or column name
+	QuoteIdent(name string) string
+	// Placeholder of n-th argument of query, starts from 1
+	Placeholder(n int) string
+	// Queries to create version table and to insert it's initial row
+	CreateVersionTable(table string) []string
+	// Query to create history table
+	CreateHistoryTable(table string) string
+	// Query and arguments which return number of tables with the name.
+	// Table without schema is searched in the current schema.
+	TableExists(table string) (string, []any)
+	// Query which returns version and dirty flag
+	SelectVersion(table string) string
+	// Query which sets version from the first argument and clears dirty flag
+	UpdateVersion(table string) string
+	// Query which marks migrations as dirty
+	SetDirty(table string) string
+	// DDL is rolled back with transaction. Otherwise dirty flag is kept
+	// after rolled back migration because its DDL may be applied.
+	TransactionalDDL() bool
+	// Acquire database-level lock waiting at most timeout and return function
+	// which releases it. Returns error wrapping ErrLockTimeout if lock was not acquired.
+	//
+	// db is a single connection which runs migrations under the lock,
+	// so session locks can be held by it.
+	Lock(ctx context.Context, db DB, table string, timeout time.Duration) (unlock func(ctx context.Context) error, err error)
+}
+
+// Select dialect by the driver of db. Driver is detected by it's package,
+// so drivers are not imported by pms. PostgreSQL is used for unknown drivers.
+func detectDialect(db DB) Dialect {
+	d, ok := db.(interface{ Driver() driver.Driver })
+	if !ok || d.Driver() == nil {
+		return &PostgresDialect{}
+	}
+
+	driverType := reflect.TypeOf(d.Driver())
+	for driverType.Kind() == reflect.Pointer {
+		driverType = driverType.Elem()
+	}
+	pkg := driverType.PkgPath()
+	switch {
+	case strings.Contains(pkg, "go-sql-driver/mysql"):
+		return &MySQLDialect{}
+	case strings.Contains(pkg, "sqlite"):
+		return &SQLiteDialect{}
+	default:
+		return &PostgresDialect{}
+	}
+}
+
+// Join schema and table name. Table isn't qualified if schema is empty.
+func qualifiedTable(schema, table string) string {
+	if schema == "" {
+		return table
+	}
+	return schema + "." + table
+}
+
+// Split table name qualified with schema. Schema is empty if table isn't qualified.
+func splitTable(table string) (string, string) {
+	schema, name, found := strings.Cut(table, ".")
+	if !found {
+		return "", table
+	}
+	return schema, name
+}
+
+// Quote every part of table name which may be qualified with schema
+func quoteTable(d Dialect, table string) string {
+	schema, name := splitTable(table)
+	if schema == "" {
+		return d.QuoteIdent(name)
+	}
+	return d.QuoteIdent(schema) + "." + d.QuoteIdent(name)
+}
+
+func selectVersionQuery(d Dialect, table string) string {
+	return fmt.Sprintf(SELECT_VERSION, quoteTable(d, table))
+}
+
+func updateVersionQuery(d Dialect, table string) string {
+	return fmt.Sprintf(QUERY_UPDATE_VERSION, quoteTable(d, table), d.Placeholder(1))
+}
+
+func setDirtyQuery(d Dialect, table string) string {
+	return fmt.Sprintf(QUERY_SET_DIRTY, quoteTable(d, table))
+}
+
+func selectHistoryQuery(d Dialect, table string) string {
+	return fmt.Sprintf(SELECT_HISTORY, quoteTable(d, table))
+}
+
+func insertHistoryQuery(d Dialect, table string) string {
+	placeholders := make([]string, 8)
+	for i := range placeholders {
+		placeholders[i] = d.Placeholder(i + 1)
+	}
+	return fmt.Sprintf(QUERY_INSERT_HISTORY, quoteTable(d, table), strings.Join(placeholders, ", "))
+}
@@ -0,0 +1,132 @@
+package pms
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	MYSQL_CREATE_TABLE = `CREATE TABLE %s (
+		version BIGINT NOT NULL DEFAULT 0,
+		dirty BOOLEAN NOT NULL DEFAULT FALSE
+	)`
+	MYSQL_INSERT_VERSION       = "INSERT INTO %s (version) VALUES (0)"
+	MYSQL_CREATE_HISTORY_TABLE = `CREATE TABLE %s (
+		id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		version BIGINT NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		direction VARCHAR(4) NOT NULL,
+		checksum VARCHAR(64) NOT NULL,
+		applied_at DATETIME(6) NOT NULL,
+		execution_time_ms BIGINT NOT NULL,
+		hostname VARCHAR(255) NOT NULL,
+		applied_by VARCHAR(255) NOT NULL
+	)`
+	MYSQL_TABLE_EXISTS           = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+	MYSQL_TABLE_EXISTS_IN_SCHEMA = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = ? AND table_name = ?"
+	MYSQL_SELECT_DATABASE        = "SELECT DATABASE()"
+	MYSQL_LOCK                   = "SELECT GET_LOCK(?, ?)"
+	MYSQL_UNLOCK                 = "SELECT RELEASE_LOCK(?)"
+)
+
+// Dialect of MySQL and MariaDB. Locks with GET_LOCK.
+type MySQLDialect struct{}
+
+func (d *MySQLDialect) Name() string {
+	return "mysql"
+}
+
+func (d *MySQLDialect) QuoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func (d *MySQLDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (d *MySQLDialect) CreateVersionTable(table string) []string {
+	return []string{
+		fmt.Sprintf(MYSQL_CREATE_TABLE, quoteTable(d, table)),
+		fmt.Sprintf(MYSQL_INSERT_VERSION, quoteTable(d, table)),
+	}
+}
+
+func (d *MySQLDialect) CreateHistoryTable(table string) string {
+	return fmt.Sprintf(MYSQL_CREATE_HISTORY_TABLE, quoteTable(d, table))
+}
+
+// Schema of MySQL is a database
+func (d *MySQLDialect) TableExists(table string) (string, []any) {
+	schema, name := splitTable(table)
+	if schema != "" {
+		return MYSQL_TABLE_EXISTS_IN_SCHEMA, []any{schema, name}
+	}
+	return MYSQL_TABLE_EXISTS, []any{name}
+}
+
+func (d *MySQLDialect) SelectVersion(table string) string {
+	return selectVersionQuery(d, table)
+}
+
+func (d *MySQLDialect) UpdateVersion(table string) string {
+	return updateVersionQuery(d, table)
+}
+
+func (d *MySQLDialect) SetDirty(table string) string {
+	return setDirtyQuery(d, table)
+}
+
+// DDL causes implicit commit in MySQL
+func (d *MySQLDialect) TransactionalDDL() bool {
+	return false
+}
+
+func (d *MySQLDialect) SplitSyntax() SplitSyntax {
+	return SplitSyntax{Backticks: true, HashComments: true, BackslashEscapes: true, DelimiterCommand: true}
+}
+
+// Lock is held by the session of db connection. Name of the lock is a hash
+// of the table qualified with its database because MySQL locks are server-wide.
+func (d *MySQLDialect) Lock(ctx context.Context, db DB, table string, timeout time.Duration) (func(ctx context.Context) error, error) {
+	schema, name := splitTable(table)
+	if schema == "" {
+		var database sql.NullString
+		if err := db.QueryRowContext(ctx, MYSQL_SELECT_DATABASE).Scan(&database); err != nil {
+			return nil, fmt.Errorf("cannot read current database: %w", err)
+		}
+		if !database.Valid {
+			return nil, fmt.Errorf("no database is selected, set it in connection or qualify table %q with it", table)
+		}
+		schema = database.String
+	}
+	lockName := mysqlLockName(qualifiedTable(schema, name))
+
+	// GET_LOCK takes whole seconds, shorter timeout shouldn't mean no waiting
+	seconds := int(math.Ceil(timeout.Seconds()))
+	var acquired sql.NullInt64
+	err := db.QueryRowContext(ctx, MYSQL_LOCK, lockName, seconds).Scan(&acquired)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %s", ErrLockTimeout, err)
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		return nil, fmt.Errorf("%w: timeout %s exceeded", ErrLockTimeout, timeout)
+	}
+
+	return func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, MYSQL_UNLOCK, lockName)
+		return err
+	}, nil
+}
+
+// Name of GET_LOCK is limited by 64 characters,
+// so table qualified with database is hashed
+func mysqlLockName(table string) string {
+	return fmt.Sprintf("pms_%016x", uint64(lockKey(table)))
+}
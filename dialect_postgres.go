@@ -0,0 +1,109 @@
+package pms
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	POSTGRES_CREATE_TABLE = `CREATE TABLE %s (
+		version BIGINT NOT NULL DEFAULT 0,
+		dirty BOOLEAN NOT NULL DEFAULT FALSE
+	)`
+	POSTGRES_INSERT_VERSION       = "INSERT INTO %s (version) VALUES (0)"
+	POSTGRES_CREATE_HISTORY_TABLE = `CREATE TABLE %s (
+		id SERIAL PRIMARY KEY,
+		version BIGINT NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		direction VARCHAR(4) NOT NULL,
+		checksum VARCHAR(64) NOT NULL,
+		applied_at TIMESTAMP NOT NULL,
+		execution_time_ms BIGINT NOT NULL,
+		hostname VARCHAR(255) NOT NULL,
+		applied_by VARCHAR(255) NOT NULL
+	)`
+	POSTGRES_TABLE_EXISTS           = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1"
+	POSTGRES_TABLE_EXISTS_IN_SCHEMA = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = $1 AND table_name = $2"
+	POSTGRES_LOCK                   = "SELECT pg_advisory_lock($1)"
+	POSTGRES_UNLOCK                 = "SELECT pg_advisory_unlock($1)"
+)
+
+// Dialect of PostgreSQL. Locks with pg_advisory_lock.
+type PostgresDialect struct{}
+
+func (d *PostgresDialect) Name() string {
+	return "postgres"
+}
+
+func (d *PostgresDialect) QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (d *PostgresDialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (d *PostgresDialect) CreateVersionTable(table string) []string {
+	return []string{
+		fmt.Sprintf(POSTGRES_CREATE_TABLE, quoteTable(d, table)),
+		fmt.Sprintf(POSTGRES_INSERT_VERSION, quoteTable(d, table)),
+	}
+}
+
+func (d *PostgresDialect) CreateHistoryTable(table string) string {
+	return fmt.Sprintf(POSTGRES_CREATE_HISTORY_TABLE, quoteTable(d, table))
+}
+
+func (d *PostgresDialect) TableExists(table string) (string, []any) {
+	schema, name := splitTable(table)
+	if schema != "" {
+		return POSTGRES_TABLE_EXISTS_IN_SCHEMA, []any{schema, name}
+	}
+	return POSTGRES_TABLE_EXISTS, []any{name}
+}
+
+func (d *PostgresDialect) SelectVersion(table string) string {
+	return selectVersionQuery(d, table)
+}
+
+func (d *PostgresDialect) UpdateVersion(table string) string {
+	return updateVersionQuery(d, table)
+}
+
+func (d *PostgresDialect) SetDirty(table string) string {
+	return setDirtyQuery(d, table)
+}
+
+func (d *PostgresDialect) TransactionalDDL() bool {
+	return true
+}
+
+func (d *PostgresDialect) SplitSyntax() SplitSyntax {
+	return SplitSyntax{DollarQuotes: true}
+}
+
+// Lock is held by the session of db connection. Waiting is limited
+// with context timeout which cancels the query.
+func (d *PostgresDialect) Lock(ctx context.Context, db DB, table string, timeout time.Duration) (func(ctx context.Context) error, error) {
+	lockCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_, err := db.ExecContext(lockCtx, POSTGRES_LOCK, lockKey(table))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if lockCtx.Err() != nil {
+			return nil, fmt.Errorf("%w: timeout %s exceeded", ErrLockTimeout, timeout)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrLockTimeout, err)
+	}
+
+	return func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, POSTGRES_UNLOCK, lockKey(table))
+		return err
+	}, nil
+}
@@ -0,0 +1,206 @@
+package pms
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	SQLITE_CREATE_TABLE = `CREATE TABLE %s (
+		version INTEGER NOT NULL DEFAULT 0,
+		dirty BOOLEAN NOT NULL DEFAULT FALSE
+	)`
+	SQLITE_INSERT_VERSION       = "INSERT INTO %s (version) VALUES (0)"
+	SQLITE_CREATE_HISTORY_TABLE = `CREATE TABLE %s (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		version INTEGER NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		direction VARCHAR(4) NOT NULL,
+		checksum VARCHAR(64) NOT NULL,
+		applied_at TIMESTAMP NOT NULL,
+		execution_time_ms INTEGER NOT NULL,
+		hostname VARCHAR(255) NOT NULL,
+		applied_by VARCHAR(255) NOT NULL
+	)`
+	SQLITE_TABLE_EXISTS           = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	SQLITE_TABLE_EXISTS_IN_SCHEMA = "SELECT COUNT(*) FROM %s.sqlite_master WHERE type = 'table' AND name = ?"
+	SQLITE_CREATE_LOCK_TABLE      = `CREATE TABLE IF NOT EXISTS %s (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		locked_at TIMESTAMP NOT NULL,
+		hostname VARCHAR(255) NOT NULL,
+		pid INTEGER NOT NULL
+	)`
+	SQLITE_BEGIN_IMMEDIATE = "BEGIN IMMEDIATE"
+	SQLITE_COMMIT          = "COMMIT"
+	SQLITE_ROLLBACK        = "ROLLBACK"
+	SQLITE_SELECT_LOCK     = "SELECT hostname, pid, locked_at FROM %s"
+	SQLITE_INSERT_LOCK     = "INSERT OR REPLACE INTO %s (id, locked_at, hostname, pid) VALUES (1, ?, ?, ?)"
+	SQLITE_DELETE_LOCK     = "DELETE FROM %s WHERE hostname = ? AND pid = ?"
+
+	SQLITE_LOCK_RETRY_INTERVAL = 100 * time.Millisecond
+)
+
+// Dialect of SQLite. Tables are checked in sqlite_master
+// because SQLite has no information_schema. DDL is transactional,
+// so failed migration is rolled back completely.
+type SQLiteDialect struct{}
+
+func (d *SQLiteDialect) Name() string {
+	return "sqlite"
+}
+
+func (d *SQLiteDialect) QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (d *SQLiteDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (d *SQLiteDialect) CreateVersionTable(table string) []string {
+	return []string{
+		fmt.Sprintf(SQLITE_CREATE_TABLE, quoteTable(d, table)),
+		fmt.Sprintf(SQLITE_INSERT_VERSION, quoteTable(d, table)),
+	}
+}
+
+func (d *SQLiteDialect) CreateHistoryTable(table string) string {
+	return fmt.Sprintf(SQLITE_CREATE_HISTORY_TABLE, quoteTable(d, table))
+}
+
+// Schema of SQLite is a name of attached database
+func (d *SQLiteDialect) TableExists(table string) (string, []any) {
+	schema, name := splitTable(table)
+	if schema != "" {
+		return fmt.Sprintf(SQLITE_TABLE_EXISTS_IN_SCHEMA, d.QuoteIdent(schema)), []any{name}
+	}
+	return SQLITE_TABLE_EXISTS, []any{name}
+}
+
+func (d *SQLiteDialect) SelectVersion(table string) string {
+	return selectVersionQuery(d, table)
+}
+
+func (d *SQLiteDialect) UpdateVersion(table string) string {
+	return updateVersionQuery(d, table)
+}
+
+func (d *SQLiteDialect) SetDirty(table string) string {
+	return setDirtyQuery(d, table)
+}
+
+func (d *SQLiteDialect) TransactionalDDL() bool {
+	return true
+}
+
+func (d *SQLiteDialect) SplitSyntax() SplitSyntax {
+	return SplitSyntax{Backticks: true, Triggers: true}
+}
+
+// SQLite has no session locks, so lock is a row in the lock table with
+// host and pid of the process holding it. The row is checked and inserted
+// in BEGIN IMMEDIATE transaction which holds the write lock of the database
+// file, so only one process can take it.
+//
+// Row left by crashed process is taken over only if it was inserted on the
+// same host by a process which isn't running anymore. Lock of another host
+// can't be checked, so it's reported in the timeout error.
+func (d *SQLiteDialect) Lock(ctx context.Context, db DB, table string, timeout time.Duration) (func(ctx context.Context) error, error) {
+	lockTable := quoteTable(d, table+"_lock")
+	_, err := db.ExecContext(ctx, fmt.Sprintf(SQLITE_CREATE_LOCK_TABLE, lockTable))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create lock table: %w", err)
+	}
+
+	hostname, _ := getAppliedBy()
+	self := sqliteLockHolder{hostname: hostname, pid: os.Getpid()}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		holder, acquired, err := d.tryLock(ctx, db, lockTable, self)
+		if err != nil && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrLockTimeout, err)
+		}
+		if acquired {
+			break
+		}
+		if time.Now().After(deadline) {
+			if holder.hostname == "" {
+				return nil, fmt.Errorf("%w: timeout %s exceeded, database is busy", ErrLockTimeout, timeout)
+			}
+			return nil, fmt.Errorf(
+				"%w: timeout %s exceeded, lock is held by process %d on %q since %s. If that process isn't running, delete the row from %s to release the lock",
+				ErrLockTimeout, timeout, holder.pid, holder.hostname, holder.lockedAt.Format(time.RFC3339), lockTable,
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(SQLITE_LOCK_RETRY_INTERVAL):
+		}
+	}
+
+	return func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, fmt.Sprintf(SQLITE_DELETE_LOCK, lockTable), self.hostname, self.pid)
+		return err
+	}, nil
+}
+
+// Process which inserted the lock row
+type sqliteLockHolder struct {
+	hostname string
+	pid      int
+	lockedAt time.Time
+}
+
+// Lock row is stale if the process which inserted it isn't running.
+// Processes of another host can't be checked.
+func (h sqliteLockHolder) stale(hostname string) bool {
+	return h.hostname == hostname && !processExists(h.pid)
+}
+
+// Insert lock row if it does not exist or is stale. Returns holder of the
+// lock if it is taken. Holder is empty if database is busy with another writer.
+func (d *SQLiteDialect) tryLock(ctx context.Context, db DB, lockTable string, self sqliteLockHolder) (sqliteLockHolder, bool, error) {
+	var holder sqliteLockHolder
+	if _, err := db.ExecContext(ctx, SQLITE_BEGIN_IMMEDIATE); err != nil {
+		// database file is locked by another writer
+		return holder, false, nil
+	}
+
+	var lockedAt any
+	err := db.QueryRowContext(ctx, fmt.Sprintf(SQLITE_SELECT_LOCK, lockTable)).Scan(&holder.hostname, &holder.pid, &lockedAt)
+	holder.lockedAt = parseTime(lockedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows), err == nil && holder.stale(self.hostname):
+		_, err = db.ExecContext(ctx, fmt.Sprintf(SQLITE_INSERT_LOCK, lockTable), time.Now().UTC(), self.hostname, self.pid)
+		if err == nil {
+			_, err = db.ExecContext(ctx, SQLITE_COMMIT)
+			if err == nil {
+				return holder, true, nil
+			}
+		}
+	}
+
+	db.ExecContext(context.Background(), SQLITE_ROLLBACK)
+	return holder, false, err
+}
+
+// Check if process with pid is running on this host
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return !errors.Is(p.Signal(syscall.Signal(0)), os.ErrProcessDone)
+}
@@ -0,0 +1,118 @@
+package pms
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+func TestDetectDialect(t *testing.T) {
+	tests := []struct {
+		driver   string
+		expected Dialect
+	}{
+		{"postgres", &PostgresDialect{}},
+		{"mysql", &MySQLDialect{}},
+	}
+	for _, test := range tests {
+		t.Run(test.driver, func(t *testing.T) {
+			db, err := sql.Open(test.driver, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			if d := detectDialect(db); !reflect.DeepEqual(d, test.expected) {
+				t.Errorf("got %#v, expected %#v", d, test.expected)
+			}
+		})
+	}
+
+	t.Run("unknown driver", func(t *testing.T) {
+		db, _ := newSQlMock(t)
+		defer db.Close()
+
+		if d := detectDialect(db); !reflect.DeepEqual(d, &PostgresDialect{}) {
+			t.Errorf("expected postgres dialect for unknown driver, got %#v", d)
+		}
+	})
+}
+
+func TestDialectQueries(t *testing.T) {
+	tests := []struct {
+		dialect       Dialect
+		quoted        string
+		updateVersion string
+		setDirty      string
+		insertHistory string
+	}{
+		{
+			&PostgresDialect{},
+			`"my""table"`,
+			`UPDATE "migrations" SET version=$1, dirty=false`,
+			`UPDATE "billing"."migrations" SET dirty=true`,
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		},
+		{
+			&MySQLDialect{},
+			"`my\"table`",
+			"UPDATE `migrations` SET version=?, dirty=false",
+			"UPDATE `billing`.`migrations` SET dirty=true",
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		},
+		{
+			&SQLiteDialect{},
+			`"my""table"`,
+			`UPDATE "migrations" SET version=?, dirty=false`,
+			`UPDATE "billing"."migrations" SET dirty=true`,
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.dialect.Name(), func(t *testing.T) {
+			if quoted := test.dialect.QuoteIdent(`my"table`); quoted != test.quoted {
+				t.Errorf("got quoted %s, expected %s", quoted, test.quoted)
+			}
+			if query := test.dialect.UpdateVersion(TABLE_NAME); query != test.updateVersion {
+				t.Errorf("got query %q, expected %q", query, test.updateVersion)
+			}
+			if query := test.dialect.SetDirty(qualifiedTable("billing", TABLE_NAME)); query != test.setDirty {
+				t.Errorf("got query %q, expected %q", query, test.setDirty)
+			}
+			query := insertHistoryQuery(test.dialect, HISTORY_TABLE_NAME)
+			if !reflect.DeepEqual(query[len(query)-len(test.insertHistory):], test.insertHistory) {
+				t.Errorf("got query %q, expected suffix %q", query, test.insertHistory)
+			}
+			if len(test.dialect.CreateVersionTable(TABLE_NAME)) != 2 {
+				t.Error("expected queries to create table and insert initial version")
+			}
+		})
+	}
+}
+
+func TestTableExistsInSchema(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		query   string
+		args    []any
+	}{
+		{&PostgresDialect{}, POSTGRES_TABLE_EXISTS_IN_SCHEMA, []any{"billing", TABLE_NAME}},
+		{&MySQLDialect{}, MYSQL_TABLE_EXISTS_IN_SCHEMA, []any{"billing", TABLE_NAME}},
+		{&SQLiteDialect{}, `SELECT COUNT(*) FROM "billing".sqlite_master WHERE type = 'table' AND name = ?`, []any{TABLE_NAME}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.dialect.Name(), func(t *testing.T) {
+			query, args := test.dialect.TableExists(qualifiedTable("billing", TABLE_NAME))
+			if query != test.query {
+				t.Errorf("got query %q, expected %q", query, test.query)
+			}
+			if !reflect.DeepEqual(args, test.args) {
+				t.Errorf("got args %v, expected %v", args, test.args)
+			}
+		})
+	}
+}
@@ -1,7 +1,11 @@
 package pms
 
 import (
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -20,43 +24,109 @@ const (
 	LOG_WARN  LogType = "warn"
 )
 
+// Keys of structured fields of log events
+const (
+	LOG_KEY_VERSION   = "version"
+	LOG_KEY_FILE      = "file"
+	LOG_KEY_DIRECTION = "direction"
+	LOG_KEY_DURATION  = "duration"
+	LOG_KEY_ERROR     = "error"
+)
+
+// Logger of migration events. args are key-value pairs of structured
+// fields like in log/slog, so *slog.Logger implements it.
 type Logger interface {
-	Info(message ...string)
-	Error(message ...string)
-	Warn(message ...string)
+	Info(msg string, args ...any)
+	Error(msg string, args ...any)
+	Warn(msg string, args ...any)
+}
+
+// Logger which sends events with structured fields to slog handler,
+// for example slog.NewJSONHandler(os.Stderr, nil).
+func NewSlogLogger(h slog.Handler) Logger {
+	return slog.New(h)
 }
 
+// Colored text logger. Colors are disabled if w isn't a terminal
+// or NO_COLOR environment variable is set.
 type eventLogger struct {
+	w     io.Writer
+	color bool
 }
 
 func newEventLogger() Logger {
-	return &eventLogger{}
+	return NewTextLogger(os.Stdout)
+}
+
+// Logger which writes events as colored text to w. It's the default
+// logger which writes to stdout.
+func NewTextLogger(w io.Writer) Logger {
+	return &eventLogger{w: w, color: isTerminal(w) && os.Getenv("NO_COLOR") == ""}
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
 }
 
-func (l *eventLogger) formatString(lt LogType, message ...string) string {
+func (l *eventLogger) formatString(lt LogType, msg string, args ...any) string {
 	var s strings.Builder
-	switch lt {
-	case LOG_INFO:
-		s.WriteString(green)
-	case LOG_ERROR:
-		s.WriteString(redBg)
-	case LOG_WARN:
-		s.WriteString(yellowBg)
+	if l.color {
+		switch lt {
+		case LOG_INFO:
+			s.WriteString(green)
+		case LOG_ERROR:
+			s.WriteString(redBg)
+		case LOG_WARN:
+			s.WriteString(yellowBg)
+		}
+	}
+	s.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			s.WriteString(" !BADKEY=" + formatValue(args[i]))
+			break
+		}
+		s.WriteString(fmt.Sprintf(" %v=%s", args[i], formatValue(args[i+1])))
+	}
+	if l.color {
+		s.WriteString(reset)
 	}
-	s.WriteString(strings.Join(message, " "))
-	s.WriteString(reset)
 	s.WriteString("\n")
 	return s.String()
 }
 
-func (l *eventLogger) Info(message ...string) {
-	os.Stdout.WriteString(l.formatString(LOG_INFO, message...))
+// Quote value if it has spaces
+func formatValue(v any) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
 }
 
-func (l *eventLogger) Error(message ...string) {
-	os.Stdout.WriteString(l.formatString(LOG_ERROR, message...))
+func (l *eventLogger) Info(msg string, args ...any) {
+	io.WriteString(l.w, l.formatString(LOG_INFO, msg, args...))
 }
 
-func (l *eventLogger) Warn(message ...string) {
-	os.Stdout.WriteString(l.formatString(LOG_WARN, message...))
+func (l *eventLogger) Error(msg string, args ...any) {
+	io.WriteString(l.w, l.formatString(LOG_ERROR, msg, args...))
 }
+
+func (l *eventLogger) Warn(msg string, args ...any) {
+	io.WriteString(l.w, l.formatString(LOG_WARN, msg, args...))
+}
+
+// Logger which drops all events
+type nopLogger struct{}
+
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+func (nopLogger) Warn(string, ...any)  {}
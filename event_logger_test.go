@@ -0,0 +1,84 @@
+package pms
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventLoggerFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		color    bool
+		lt       LogType
+		msg      string
+		args     []any
+		expected string
+	}{
+		{"message", false, LOG_INFO, "Nothing to migrate", nil, "Nothing to migrate\n"},
+		{"fields", false, LOG_WARN, "New version", []any{LOG_KEY_VERSION, int64(3)}, "New version version=3\n"},
+		{"quoted value", false, LOG_ERROR, "Failed", []any{LOG_KEY_ERROR, errors.New("syntax error")}, "Failed error=\"syntax error\"\n"},
+		{"odd args", false, LOG_INFO, "Success", []any{LOG_KEY_FILE}, "Success !BADKEY=file\n"},
+		{"color", true, LOG_INFO, "Success", nil, green + "Success" + reset + "\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := &eventLogger{color: test.color}
+			if got := l.formatString(test.lt, test.msg, test.args...); got != test.expected {
+				t.Errorf("got %q, expected %q", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	db := newSQLiteDB(t)
+
+	var buf bytes.Buffer
+	m, err := NewFromFS(db, newSQLiteMigrations(), "migrations", WithLogger(NewSlogLogger(slog.NewJSONHandler(&buf, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Version(1); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var event map[string]any
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d: %s", len(events), buf.String())
+	}
+
+	success := events[0]
+	if success["msg"] != "Success" || success[LOG_KEY_FILE] != "migrations/1_users.up.sql" ||
+		success[LOG_KEY_DIRECTION] != "up" || success[LOG_KEY_VERSION] != float64(1) {
+		t.Errorf("not expected event %v", success)
+	}
+	if _, ok := success[LOG_KEY_DURATION]; !ok {
+		t.Errorf("expected %q field in %v", LOG_KEY_DURATION, success)
+	}
+	if events[1]["msg"] != "New version" || events[1][LOG_KEY_VERSION] != float64(1) {
+		t.Errorf("not expected event %v", events[1])
+	}
+
+	t.Run("nil disables logging", func(t *testing.T) {
+		m, err := NewFromFS(newSQLiteDB(t), newSQLiteMigrations(), "migrations", WithLogger(nil), WithLockTimeout(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Up(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
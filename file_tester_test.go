@@ -1,6 +1,7 @@
 package pms
 
 import (
+	"database/sql/driver"
 	"io/fs"
 	"os"
 	"regexp"
@@ -56,6 +57,85 @@ func (f *FileTester) CreateQueryMocks(files []TestFile, mock sqlmock.Sqlmock) {
 	}
 }
 
+// Expect execution of file content and insertion of it into history
+func (f *FileTester) CreateMigrationMocks(files []TestFile, mock sqlmock.Sqlmock) {
+	f.t.Helper()
+	for _, file := range files {
+		if !file.valid {
+			continue
+		}
+		expectMigrationFile(mock, file)
+	}
+}
+
+// Expect execution of each statement of file and insertion of it into history
+func expectMigrationFile(mock sqlmock.Sqlmock, file TestFile) {
+	statements, _ := splitStatements(string(file.content), testDialect)
+	for _, s := range statements {
+		mock.ExpectExec(regexp.QuoteMeta(s.query)).WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectExec(regexp.QuoteMeta(insertHistoryQuery(testDialect, HISTORY_TABLE_NAME))).
+		WithArgs(
+			getVersionFromName(file.name),
+			getNameFromFileName(file.name),
+			string(getDirectionFromFileName(file.name)),
+			checksum(file.content),
+			sqlmock.AnyArg(),
+			sqlmock.AnyArg(),
+			sqlmock.AnyArg(),
+			sqlmock.AnyArg(),
+		).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// Dialect which is detected for sqlmock driver
+var testDialect Dialect = &PostgresDialect{}
+
+// Expect queries of New when tables do not exist
+func expectNew(mock sqlmock.Sqlmock) {
+	mock.ExpectPing()
+	expectTableExists(mock, TABLE_NAME, false)
+	for _, query := range testDialect.CreateVersionTable(TABLE_NAME) {
+		mock.ExpectExec(regexp.QuoteMeta(query)).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	expectTableExists(mock, HISTORY_TABLE_NAME, false)
+	mock.ExpectExec(regexp.QuoteMeta(testDialect.CreateHistoryTable(HISTORY_TABLE_NAME))).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func expectTableExists(mock sqlmock.Sqlmock, tableName string, exists bool) {
+	var count int
+	if exists {
+		count = 1
+	}
+	query, args := testDialect.TableExists(tableName)
+	var driverArgs []driver.Value
+	for _, arg := range args {
+		driverArgs = append(driverArgs, arg)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(driverArgs...).WillReturnRows(mock.NewRows([]string{"count"}).AddRow(count))
+}
+
+func expectLock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta(POSTGRES_LOCK)).WithArgs(lockKey(TABLE_NAME)).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta(POSTGRES_UNLOCK)).WithArgs(lockKey(TABLE_NAME)).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func expectVersion(mock sqlmock.Sqlmock, version int64, dirty bool) {
+	rows := mock.NewRows([]string{"version", "dirty"}).AddRow(version, dirty)
+	mock.ExpectQuery(regexp.QuoteMeta(testDialect.SelectVersion(TABLE_NAME))).WillReturnRows(rows)
+}
+
+func expectSetDirty(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta(testDialect.SetDirty(TABLE_NAME))).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func expectUpdateVersion(mock sqlmock.Sqlmock, version int64) {
+	mock.ExpectExec(regexp.QuoteMeta(testDialect.UpdateVersion(TABLE_NAME))).WithArgs(version).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
 func TestFileTester(t *testing.T) {
 	t.Run("make directory", func(t *testing.T) {
 		f := FileTester{t: t}
@@ -0,0 +1,164 @@
+package pms
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+)
+
+// Returned when applied Go migration without down function should be reverted
+var ErrIrreversible = errors.New("migration can't be reverted")
+
+// Migration written in Go. It runs inside of the same transaction
+// as SQL files and its changes are rolled back if any migration fails.
+type MigrationFunc func(ctx context.Context, tx *sql.Tx) error
+
+// Go migration registered with Register
+type goMigration struct {
+	name string
+	up   MigrationFunc
+	down MigrationFunc
+}
+
+// Single step of migration: SQL file or Go function
+type migration struct {
+	version   int64
+	name      string
+	direction Direction
+	// Name of file inside of migrations directory. Empty for Go migration.
+	fileName string
+	// Function of Go migration. Nil for SQL file and for
+	// down direction of Go migration without down function.
+	fn MigrationFunc
+	// File has `-- pms:no-transaction` directive
+	noTransaction bool
+}
+
+func (mg migration) isGo() bool {
+	return mg.fileName == ""
+}
+
+// Go migration without down function in down direction
+func (mg migration) isIrreversible() bool {
+	return mg.isGo() && mg.fn == nil
+}
+
+// Returns error wrapping ErrIrreversible if one of migrations can't be
+// reverted, so nothing is reverted past it
+func checkReversible(pending []migration) error {
+	for _, mg := range pending {
+		if mg.isIrreversible() {
+			return fmt.Errorf("%w: go migration %q has no down function", ErrIrreversible, goMigrationSource(mg.version, mg.name))
+		}
+	}
+	return nil
+}
+
+// Path of file or name of Go migration to show in logs
+func (mg migration) source(dir string) string {
+	if mg.isGo() {
+		return goMigrationSource(mg.version, mg.name)
+	}
+	return path.Join(dir, mg.fileName)
+}
+
+func goMigrationSource(version int64, name string) string {
+	return fmt.Sprintf("go:%d_%s", version, name)
+}
+
+// Checksum of Go migration stored in history table.
+// Code of function can't be hashed so it depends only on name.
+func goMigrationChecksum(name string) string {
+	return checksum([]byte("go:" + name))
+}
+
+// Register Go function as migration with the provided version.
+//
+// Registered migrations are ordered by version together with files
+// and run by Up, Down and Version. Down function may be nil if
+// migration can't be reverted, then Down, Version, Steps and Plan return
+// an error wrapping ErrIrreversible instead of reverting it.
+//
+//	m.Register(7, "backfill_slugs", func(ctx context.Context, tx *sql.Tx) error {
+//		_, err := tx.ExecContext(ctx, "UPDATE posts SET slug = lower(title)")
+//		return err
+//	}, nil)
+func (m *Migration) Register(version int64, name string, up, down MigrationFunc) error {
+	if version <= 0 {
+		return fmt.Errorf("version of go migration %q should be positive, got %d", name, version)
+	}
+	if name == "" {
+		return fmt.Errorf("name of go migration %d should not be empty", version)
+	}
+	if up == nil {
+		return fmt.Errorf("go migration %d %q should have up function", version, name)
+	}
+	if registered, ok := m.goMigrations[version]; ok {
+		return fmt.Errorf("version %d already registered by go migration %q", version, registered.name)
+	}
+
+	files, err := readDir(m.fsys, m.dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !file.IsDir() && getVersionFromName(file.Name()) == version {
+			return fmt.Errorf("version %d of go migration %q already used by file %q", version, name, file.Name())
+		}
+	}
+
+	if m.goMigrations == nil {
+		m.goMigrations = make(map[int64]goMigration)
+	}
+	m.goMigrations[version] = goMigration{name: name, up: up, down: down}
+	return nil
+}
+
+// Files and registered Go functions with provided direction ordered by version
+func (m *Migration) getMigrations(direction Direction) ([]migration, error) {
+	files, err := readDir(m.fsys, m.dir)
+	if err != nil {
+		return nil, err
+	}
+	filesToRead, err := getFilesWithDirection(files, direction)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]migration, 0, len(filesToRead)+len(m.goMigrations))
+	for _, file := range filesToRead {
+		content, err := getFileContent(m.fsys, m.dir, file.Name())
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{
+			version:       getVersionFromName(file.Name()),
+			name:          getNameFromFileName(file.Name()),
+			direction:     direction,
+			fileName:      file.Name(),
+			noTransaction: hasDirective(content, DIRECTIVE_NO_TRANSACTION),
+		})
+	}
+
+	for version, g := range m.goMigrations {
+		fn := g.up
+		if direction == DIRECTION_DOWN {
+			fn = g.down
+		}
+		migrations = append(migrations, migration{
+			version:   version,
+			name:      g.name,
+			direction: direction,
+			fn:        fn,
+		})
+	}
+
+	sort.SliceStable(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	return migrations, nil
+}
@@ -0,0 +1,214 @@
+package pms
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRegister(t *testing.T) {
+	noop := func(ctx context.Context, tx *sql.Tx) error { return nil }
+
+	tests := []struct {
+		name    string
+		version int64
+		mName   string
+		up      MigrationFunc
+		wantErr bool
+	}{
+		{name: "valid", version: 7, mName: "backfill_slugs", up: noop},
+		{name: "zero version", version: 0, mName: "backfill_slugs", up: noop, wantErr: true},
+		{name: "empty name", version: 7, mName: "", up: noop, wantErr: true},
+		{name: "nil up", version: 7, mName: "backfill_slugs", wantErr: true},
+		{name: "version of file", version: 2, mName: "backfill_slugs", up: noop, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := NewFromFS(newSQLiteDB(t), newSQLiteMigrations(), "migrations")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = m.Register(test.version, test.mName, test.up, nil)
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error %t, got %v", test.wantErr, err)
+			}
+		})
+	}
+
+	t.Run("duplicate", func(t *testing.T) {
+		m, err := NewFromFS(newSQLiteDB(t), newSQLiteMigrations(), "migrations")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Register(7, "first", noop, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Register(7, "second", noop, nil); err == nil {
+			t.Error("expected error for duplicated version")
+		}
+	})
+}
+
+func TestGoMigrations(t *testing.T) {
+	db := newSQLiteDB(t)
+	fsys := newSQLiteMigrations()
+	fsys["migrations/10_tags.up.sql"] = fsys["migrations/3_comments.up.sql"]
+	fsys["migrations/10_tags.down.sql"] = fsys["migrations/3_comments.down.sql"]
+	delete(fsys, "migrations/3_comments.up.sql")
+	delete(fsys, "migrations/3_comments.down.sql")
+
+	m, err := NewFromFS(db, fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []string
+	err = m.Register(5, "seed_users",
+		func(ctx context.Context, tx *sql.Tx) error {
+			calls = append(calls, "up")
+			_, err := tx.ExecContext(ctx, "INSERT INTO users (name) VALUES ('admin')")
+			return err
+		},
+		func(ctx context.Context, tx *sql.Tx) error {
+			calls = append(calls, "down")
+			_, err := tx.ExecContext(ctx, "DELETE FROM users")
+			return err
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, err := m.(*Migration).getMigrations(DIRECTION_UP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []int64
+	for _, mg := range migrations {
+		versions = append(versions, mg.version)
+	}
+	if len(versions) != 4 || versions[0] != 1 || versions[1] != 2 || versions[2] != 5 || versions[3] != 10 {
+		t.Fatalf("expected migrations ordered by version [1 2 5 10], got %v", versions)
+	}
+
+	if err := m.Version(5); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 5, false)
+	if count := countSQLiteRows(t, db, "users"); count != 1 {
+		t.Errorf("expected 1 seeded user, got %d", count)
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 10, false)
+
+	err = m.Validate()
+	var checksumErr *ChecksumError
+	if errors.As(err, &checksumErr) {
+		t.Errorf("expected valid checksums, got %v", err)
+	}
+	var dirErr *DirectoryError
+	if !errors.As(err, &dirErr) || len(dirErr.Problems) != 2 {
+		t.Errorf("expected only gaps 3-4 and 6-9 in directory, got %v", err)
+	}
+
+	if err := m.Version(2); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 2, false)
+	if count := countSQLiteRows(t, db, "users"); count != 0 {
+		t.Errorf("expected users to be deleted by down function, got %d", count)
+	}
+
+	if len(calls) != 2 || calls[0] != "up" || calls[1] != "down" {
+		t.Errorf("expected up and down calls, got %v", calls)
+	}
+	if count := countSQLiteRows(t, db, HISTORY_TABLE_NAME); count != 6 {
+		t.Errorf("expected 6 rows in history, got %d", count)
+	}
+}
+
+func TestGoMigrationFailed(t *testing.T) {
+	db := newSQLiteDB(t)
+	m, err := NewFromFS(db, newSQLiteMigrations(), "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errFailed := errors.New("cannot backfill")
+	err = m.Register(4, "backfill", func(ctx context.Context, tx *sql.Tx) error {
+		return errFailed
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Up()
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("expected error %v, got %v", errFailed, err)
+	}
+	assertSQLiteVersion(t, db, 0, false)
+	assertSQLiteTables(t, db, map[string]bool{"users": false, "posts": false, "comments": false})
+}
+
+func TestGoMigrationIrreversible(t *testing.T) {
+	db := newSQLiteDB(t)
+	fsys := fstest.MapFS{
+		"migrations/1_a.up.sql":   {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		"migrations/1_a.down.sql": {Data: []byte("DROP TABLE a;")},
+		"migrations/3_c.up.sql":   {Data: []byte("CREATE TABLE c (id INTEGER);")},
+		"migrations/3_c.down.sql": {Data: []byte("DROP TABLE c;")},
+	}
+	m, err := NewFromFS(db, fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	err = m.Register(2, "b", func(ctx context.Context, tx *sql.Tx) error {
+		calls++
+		_, err := tx.ExecContext(ctx, "INSERT INTO a (id) VALUES (1)")
+		return err
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Down(); !errors.Is(err, ErrIrreversible) {
+		t.Errorf("Down: expected ErrIrreversible, got %v", err)
+	}
+	if err := m.Version(1); !errors.Is(err, ErrIrreversible) {
+		t.Errorf("Version: expected ErrIrreversible, got %v", err)
+	}
+	if err := m.Steps(-2); !errors.Is(err, ErrIrreversible) {
+		t.Errorf("Steps: expected ErrIrreversible, got %v", err)
+	}
+	if _, err := m.Plan(0); !errors.Is(err, ErrIrreversible) {
+		t.Errorf("Plan: expected ErrIrreversible, got %v", err)
+	}
+	assertSQLiteVersion(t, db, 3, false)
+
+	if err := m.Steps(-1); err != nil {
+		t.Fatalf("Steps before irreversible migration: %v", err)
+	}
+	assertSQLiteVersion(t, db, 2, false)
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("expected up of go migration to run once, got %d", calls)
+	}
+	if count := countSQLiteRows(t, db, "a"); count != 1 {
+		t.Errorf("expected 1 row in a, got %d", count)
+	}
+}
@@ -0,0 +1,110 @@
+package pms
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+const DEFAULT_LOCK_TIMEOUT = 15 * time.Second
+
+// Returned when migration lock cannot be acquired in time
+var ErrLockTimeout = errors.New("cannot acquire migration lock")
+
+// Key of advisory lock for the table with migrations
+func lockKey(tableName string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("pms:" + tableName))
+	return int64(h.Sum64())
+}
+
+// Run fn while holding migration lock of dialect.
+//
+// Lock and migrations use one connection of the pool, because session
+// locks are held by the connection and pool may have no other one.
+// fn gets a copy of Migration which runs queries in that connection.
+func (m *Migration) withLock(ctx context.Context, fn func(m *Migration, ctx context.Context) error) error {
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		m.l.Error("Cannot acquire migration lock", LOG_KEY_ERROR, err)
+		return fmt.Errorf("cannot get connection for migration lock: %w", err)
+	}
+	locked := *m
+	locked.db = &lockConn{conn: conn}
+	defer conn.Close()
+
+	unlock, err := m.dialect.Lock(ctx, locked.db, m.table, m.lockTimeout)
+	if err != nil {
+		m.l.Error("Cannot acquire migration lock", LOG_KEY_ERROR, err)
+		return err
+	}
+	defer func() {
+		// release the lock even if ctx is already done
+		if err := unlock(context.Background()); err != nil {
+			m.l.Error("Cannot release migration lock", LOG_KEY_ERROR, err)
+		}
+	}()
+
+	return fn(&locked, ctx)
+}
+
+// Connection of migration lock used as DB. It can't provide
+// another connection, statements of files outside of transaction
+// run in it directly.
+type lockConn struct {
+	conn *sql.Conn
+}
+
+func (c *lockConn) Begin() (*sql.Tx, error) {
+	return c.conn.BeginTx(context.Background(), nil)
+}
+
+func (c *lockConn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return c.conn.BeginTx(ctx, opts)
+}
+
+func (c *lockConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *lockConn) Conn(ctx context.Context) (*sql.Conn, error) {
+	return nil, errors.New("connection of migration lock can't provide another connection")
+}
+
+func (c *lockConn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.conn.ExecContext(context.Background(), query, args...)
+}
+
+func (c *lockConn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return c.conn.ExecContext(ctx, query, args...)
+}
+
+func (c *lockConn) Ping() error {
+	return c.conn.PingContext(context.Background())
+}
+
+func (c *lockConn) PingContext(ctx context.Context) error {
+	return c.conn.PingContext(ctx)
+}
+
+func (c *lockConn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.conn.QueryContext(context.Background(), query, args...)
+}
+
+func (c *lockConn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return c.conn.QueryContext(ctx, query, args...)
+}
+
+func (c *lockConn) QueryRow(query string, args ...any) *sql.Row {
+	return c.conn.QueryRowContext(context.Background(), query, args...)
+}
+
+func (c *lockConn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return c.conn.QueryRowContext(ctx, query, args...)
+}
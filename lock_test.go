@@ -0,0 +1,202 @@
+package pms
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestWithLock(t *testing.T) {
+	t.Run("postgres", func(t *testing.T) {
+		db, mock := newSQlMock(t)
+		defer db.Close()
+
+		mock.ExpectExec(regexp.QuoteMeta(POSTGRES_LOCK)).WithArgs(lockKey(TABLE_NAME)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(POSTGRES_UNLOCK)).WithArgs(lockKey(TABLE_NAME)).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		m := &Migration{db: db, l: newEventLogger(), table: TABLE_NAME, dialect: &PostgresDialect{}, lockTimeout: time.Second}
+		var called bool
+		err := m.withLock(context.Background(), func(m *Migration, ctx context.Context) error {
+			called = true
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		if !called {
+			t.Error("expected to call function under lock")
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("postgres timeout", func(t *testing.T) {
+		db, mock := newSQlMock(t)
+		defer db.Close()
+
+		mock.ExpectExec(regexp.QuoteMeta(POSTGRES_LOCK)).WithArgs(lockKey(TABLE_NAME)).WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		m := &Migration{db: db, l: newEventLogger(), table: TABLE_NAME, dialect: &PostgresDialect{}, lockTimeout: 10 * time.Millisecond}
+		err := m.withLock(context.Background(), func(m *Migration, ctx context.Context) error {
+			t.Error("function should not be called without lock")
+			return nil
+		})
+		if !errors.Is(err, ErrLockTimeout) {
+			t.Errorf("expected ErrLockTimeout, got %v", err)
+		}
+	})
+
+	t.Run("pool of one connection", func(t *testing.T) {
+		db, mock := newSQlMock(t)
+		defer db.Close()
+		up := TestFile{true, "1_users.up.sql", []byte("CREATE TABLE users(id SERIAL)")}
+		fsys := fstest.MapFS{
+			"migrations/" + up.name:       {Data: up.content},
+			"migrations/1_users.down.sql": {Data: []byte("DROP TABLE users")},
+		}
+
+		expectNew(mock)
+		m, err := NewFromFS(db, fsys, "migrations", WithLogger(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.SetMaxOpenConns(1)
+
+		expectLock(mock)
+		expectVersion(mock, 0, false)
+		expectSetDirty(mock)
+		mock.ExpectBegin()
+		expectMigrationFile(mock, up)
+		expectUpdateVersion(mock, 1)
+		mock.ExpectCommit()
+		expectUnlock(mock)
+
+		// lock must not wait for another connection of the pool
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := m.UpContext(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	lockName := mysqlLockName("app." + TABLE_NAME)
+
+	t.Run("mysql", func(t *testing.T) {
+		db, mock := newSQlMock(t)
+		defer db.Close()
+
+		mock.ExpectQuery(regexp.QuoteMeta(MYSQL_SELECT_DATABASE)).WillReturnRows(mock.NewRows([]string{"database"}).AddRow("app"))
+		mock.ExpectQuery(regexp.QuoteMeta(MYSQL_LOCK)).WithArgs(lockName, 5).WillReturnRows(mock.NewRows([]string{"lock"}).AddRow(1))
+		mock.ExpectExec(regexp.QuoteMeta(MYSQL_UNLOCK)).WithArgs(lockName).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		m := &Migration{db: db, l: newEventLogger(), table: TABLE_NAME, dialect: &MySQLDialect{}, lockTimeout: 5 * time.Second}
+		err := m.withLock(context.Background(), func(m *Migration, ctx context.Context) error { return nil })
+		if err != nil {
+			t.Error(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("mysql timeout under second", func(t *testing.T) {
+		db, mock := newSQlMock(t)
+		defer db.Close()
+
+		mock.ExpectQuery(regexp.QuoteMeta(MYSQL_SELECT_DATABASE)).WillReturnRows(mock.NewRows([]string{"database"}).AddRow("app"))
+		mock.ExpectQuery(regexp.QuoteMeta(MYSQL_LOCK)).WithArgs(lockName, 1).WillReturnRows(mock.NewRows([]string{"lock"}).AddRow(1))
+		mock.ExpectExec(regexp.QuoteMeta(MYSQL_UNLOCK)).WithArgs(lockName).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		m := &Migration{db: db, l: newEventLogger(), table: TABLE_NAME, dialect: &MySQLDialect{}, lockTimeout: 300 * time.Millisecond}
+		err := m.withLock(context.Background(), func(m *Migration, ctx context.Context) error { return nil })
+		if err != nil {
+			t.Error(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("mysql cancelled", func(t *testing.T) {
+		db, mock := newSQlMock(t)
+		defer db.Close()
+
+		mock.ExpectQuery(regexp.QuoteMeta(MYSQL_SELECT_DATABASE)).WillReturnRows(mock.NewRows([]string{"database"}).AddRow("app"))
+		mock.ExpectQuery(regexp.QuoteMeta(MYSQL_LOCK)).WithArgs(lockName, 5).WillDelayFor(time.Second).WillReturnRows(mock.NewRows([]string{"lock"}).AddRow(1))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := (&MySQLDialect{}).Lock(ctx, db, TABLE_NAME, 5*time.Second)
+		if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrLockTimeout) {
+			t.Errorf("expected error of context, got %v", err)
+		}
+	})
+
+	t.Run("postgres cancelled", func(t *testing.T) {
+		db, mock := newSQlMock(t)
+		defer db.Close()
+
+		mock.ExpectExec(regexp.QuoteMeta(POSTGRES_LOCK)).WithArgs(lockKey(TABLE_NAME)).WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := (&PostgresDialect{}).Lock(ctx, db, TABLE_NAME, 5*time.Second)
+		if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrLockTimeout) {
+			t.Errorf("expected error of context, got %v", err)
+		}
+	})
+
+	t.Run("mysql timeout", func(t *testing.T) {
+		db, mock := newSQlMock(t)
+		defer db.Close()
+
+		mock.ExpectQuery(regexp.QuoteMeta(MYSQL_SELECT_DATABASE)).WillReturnRows(mock.NewRows([]string{"database"}).AddRow("app"))
+		mock.ExpectQuery(regexp.QuoteMeta(MYSQL_LOCK)).WithArgs(lockName, 1).WillReturnRows(mock.NewRows([]string{"lock"}).AddRow(0))
+
+		d := &MySQLDialect{}
+		_, err := d.Lock(context.Background(), db, TABLE_NAME, time.Second)
+		if !errors.Is(err, ErrLockTimeout) {
+			t.Errorf("expected ErrLockTimeout, got %v", err)
+		}
+	})
+	t.Run("mysql schema", func(t *testing.T) {
+		db, mock := newSQlMock(t)
+		defer db.Close()
+
+		table := strings.Repeat("s", 64) + "." + strings.Repeat("t", 64)
+		name := mysqlLockName(table)
+		if len(name) > 64 {
+			t.Errorf("expected name of lock up to 64 characters, got %q", name)
+		}
+		mock.ExpectQuery(regexp.QuoteMeta(MYSQL_LOCK)).WithArgs(name, 1).WillReturnRows(mock.NewRows([]string{"lock"}).AddRow(1))
+
+		if _, err := (&MySQLDialect{}).Lock(context.Background(), db, table, time.Second); err != nil {
+			t.Error(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("mysql without database", func(t *testing.T) {
+		db, mock := newSQlMock(t)
+		defer db.Close()
+
+		mock.ExpectQuery(regexp.QuoteMeta(MYSQL_SELECT_DATABASE)).WillReturnRows(mock.NewRows([]string{"database"}).AddRow(nil))
+
+		_, err := (&MySQLDialect{}).Lock(context.Background(), db, TABLE_NAME, time.Second)
+		if err == nil || !strings.Contains(err.Error(), "no database is selected") {
+			t.Errorf("expected error of missing database, got %v", err)
+		}
+	})
+}
@@ -1,24 +1,59 @@
 package pms
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
 )
 
 const (
-	TABLE_NAME         = "migrations"
-	QUERY_CREATE_TABLE = `CREATE TABLE %s (
-		version VARCHAR(255) NOT NULL DEFAULT 0
-	);
-	INSERT INTO migrations (version) VALUES (0);`
-	QUERY_UPDATE_VERSION = "UPDATE %s SET version=%d"
+	TABLE_NAME = "migrations"
+	// History table is named after version table with this suffix
+	HISTORY_TABLE_SUFFIX = "_history"
+	HISTORY_TABLE_NAME   = TABLE_NAME + HISTORY_TABLE_SUFFIX
+
+	// Header comment of file to run it outside of transaction
+	DIRECTIVE_NO_TRANSACTION = "pms:no-transaction"
 
 	ERROR_EQUAL_VERSION = "current version %d equals current"
 	ERROR_UP_TO_DATE    = "migrations is up to date"
+	ERROR_DIRTY         = "the last migration from version %d failed, check the database and use Force to set the version"
+	ERROR_ZERO_STEPS    = "number of steps is 0"
 )
 
+// Returned when the last migration failed and version should be set with Force
+var ErrDirty = errors.New("migrations are dirty")
+
+// Returned by Version when database is already at the requested version
+var ErrNoChange = errors.New("no change")
+
+// Returned by methods which change database if Migration is created WithReadOnly
+var ErrReadOnly = errors.New("migrations are read-only")
+
+// Returned by New and NewFromFS when value of option is wrong
+var ErrInvalidOption = errors.New("invalid option")
+
+// Error with its own message which matches ErrNoChange
+type noChangeError struct {
+	msg string
+}
+
+func (e *noChangeError) Error() string {
+	return e.msg
+}
+
+func (e *noChangeError) Unwrap() error {
+	return ErrNoChange
+}
+
 type Direction string
 
 const (
@@ -26,74 +61,204 @@ const (
 	DIRECTION_DOWN Direction = "down"
 )
 
+// How migrations are wrapped in transactions
+type TransactionMode string
+
+const (
+	// All migrations and version update in one transaction
+	TRANSACTION_SINGLE TransactionMode = "single"
+	// Every migration and version update in its own transaction
+	TRANSACTION_PER_MIGRATION TransactionMode = "per-migration"
+	// SQL files run without transaction, Go migrations still get their own one
+	TRANSACTION_NONE TransactionMode = "none"
+)
+
 type DB interface {
 	Begin() (*sql.Tx, error)
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
 	Close() error
+	Conn(ctx context.Context) (*sql.Conn, error)
 	Exec(query string, args ...any) (sql.Result, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 	Ping() error
+	PingContext(ctx context.Context) error
 	Query(query string, args ...any) (*sql.Rows, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
 	QueryRow(query string, args ...any) *sql.Row
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
 type Migrator interface {
 	Up() error
+	UpContext(context.Context) error
 	Down() error
-	Version(int) error
+	DownContext(context.Context) error
+	Version(int64) error
+	VersionContext(context.Context, int64) error
+	Steps(int) error
+	StepsContext(context.Context, int) error
+	Validate() error
+	Force(int64) error
+	Register(version int64, name string, up, down MigrationFunc) error
+	Plan(target int64) (*Plan, error)
+	PlanSteps(n int) (*Plan, error)
+	Status() (*Status, error)
 }
 type Migration struct {
 	db   DB
-	path string
-	l    Logger
+	fsys fs.FS
+	dir  string
+	// Directory of migrations in logs and errors: path passed to New or dir of fsys
+	source  string
+	l       Logger
+	dialect Dialect
+	// Names of version and history tables qualified with schema
+	table        string
+	historyTable string
+	schema       string
+	lockTimeout  time.Duration
+	txMode       TransactionMode
+	// Apply unapplied migrations with versions lower than current
+	outOfOrder bool
+	// Don't create or change tables, only read them
+	readOnly bool
+	// Go migrations registered by version
+	goMigrations map[int64]goMigration
 }
 
-// Create new instance of Migration structure
-func New(db DB, path string) (Migrator, error) {
-	_, err := readDir(path)
-	if err != nil {
+// Create new instance of Migration structure which reads
+// migration files from the provided path of the OS filesystem.
+func New(db DB, path string, opts ...Option) (Migrator, error) {
+	path = filepath.Clean(path)
+	return newFromFS(db, os.DirFS(path), ".", filepath.ToSlash(path), opts...)
+}
+
+// Create new instance of Migration structure which reads
+// migration files from the directory dir of fsys.
+//
+// Useful to ship migrations inside of the binary with embed.FS:
+//
+//	//go:embed migrations/*.sql
+//	var migrations embed.FS
+//
+//	m, err := pms.NewFromFS(db, migrations, "migrations")
+func NewFromFS(db DB, fsys fs.FS, dir string, opts ...Option) (Migrator, error) {
+	return newFromFS(db, fsys, dir, dir, opts...)
+}
+
+// source is the name of dir in logs and errors
+func newFromFS(db DB, fsys fs.FS, dir, source string, opts ...Option) (Migrator, error) {
+	if _, err := fs.ReadDir(fsys, dir); err != nil {
+		return nil, fmt.Errorf("directory %q not found. Error: %w", source, err)
+	}
+
+	m := &Migration{
+		db:           db,
+		fsys:         fsys,
+		dir:          dir,
+		source:       source,
+		l:            newEventLogger(),
+		table:        TABLE_NAME,
+		historyTable: HISTORY_TABLE_NAME,
+		lockTimeout:  DEFAULT_LOCK_TIMEOUT,
+		txMode:       TRANSACTION_SINGLE,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.l == nil {
+		m.l = nopLogger{}
+	}
+	if m.dialect == nil {
+		m.dialect = detectDialect(db)
+	}
+	if m.table == "" {
+		return nil, fmt.Errorf("%w: table name should not be empty", ErrInvalidOption)
+	}
+	if strings.Contains(m.table, ".") {
+		return nil, fmt.Errorf("%w: table name %q should not contain '.', use WithSchema to set schema", ErrInvalidOption, m.table)
+	}
+	if strings.Contains(m.schema, ".") {
+		return nil, fmt.Errorf("%w: schema %q should not contain '.'", ErrInvalidOption, m.schema)
+	}
+	m.table = qualifiedTable(m.schema, m.table)
+	m.historyTable = qualifiedTable(m.schema, m.historyTable)
+	switch m.txMode {
+	case TRANSACTION_SINGLE, TRANSACTION_PER_MIGRATION, TRANSACTION_NONE:
+	default:
+		return nil, fmt.Errorf("%w: unknown transaction mode %q", ErrInvalidOption, m.txMode)
+	}
+
+	ctx := context.Background()
+	if err := db.PingContext(ctx); err != nil {
 		return nil, err
 	}
+	if m.readOnly {
+		return m, nil
+	}
 
-	if err := db.Ping(); err != nil {
+	exists, err := tableExists(ctx, db, m.dialect, m.table)
+	if err != nil {
 		return nil, err
 	}
+	if !exists {
+		err = createTable(ctx, db, m.dialect, m.table)
 
-	if !tableExists(db, TABLE_NAME) {
-		err = createTable(db, TABLE_NAME)
+		if err != nil {
+			return nil, err
+		}
+	} else if !columnExists(ctx, db, m.dialect, m.table, "dirty") {
+		err = addDirtyColumn(ctx, db, m.dialect, m.table)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	exists, err = tableExists(ctx, db, m.dialect, m.historyTable)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		err = createHistoryTable(ctx, db, m.dialect, m.historyTable)
 
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	return &Migration{db: db, path: path, l: newEventLogger()}, nil
+	return m, nil
 }
 
 // Run all queries from files with `up` action.
 func (m *Migration) Up() error {
-	files, err := readDir(m.path)
-	if err != nil {
-		return err
-	}
-	filesToRead, err := getFilesWithDirection(files, DIRECTION_UP)
+	return m.UpContext(context.Background())
+}
+
+// Same as Up. Migration stops and rolls back when ctx is done.
+func (m *Migration) UpContext(ctx context.Context) error {
+	return m.withLock(ctx, (*Migration).up)
+}
+
+func (m *Migration) up(ctx context.Context) error {
+	migrations, err := m.getMigrations(DIRECTION_UP)
 	if err != nil {
 		return err
 	}
 
-	migrationVersion, err := getMigrationVersion(m.db)
+	migrationVersion, err := m.currentVersion(ctx)
 	if err != nil {
 		return err
 	}
 
-	q, err := newQuerier(m.db, m.path)
+	isApplied, err := m.getApplied(ctx, migrationVersion)
 	if err != nil {
-		return nil
+		return err
 	}
 
-	var skipFile skipFileFunc = func(fileVersion int) bool {
-		return fileVersion <= migrationVersion
-	}
+	q := m.newQuerier()
 
-	err = q.RunFileQueries(-1, filesToRead, DIRECTION_UP, skipFile)
+	err = q.RunMigrations(ctx, migrationVersion, migrationVersion, migrations, DIRECTION_UP, skipUp(isApplied, -1))
 	if err != nil {
 		return err
 	}
@@ -103,29 +268,32 @@ func (m *Migration) Up() error {
 
 // Run all queries from files with `down` action.
 func (m *Migration) Down() error {
-	files, err := readDir(m.path)
-	if err != nil {
-		return err
-	}
-	filesToRead, err := getFilesWithDirection(files, DIRECTION_DOWN)
+	return m.DownContext(context.Background())
+}
+
+// Same as Down. Migration stops and rolls back when ctx is done.
+func (m *Migration) DownContext(ctx context.Context) error {
+	return m.withLock(ctx, (*Migration).down)
+}
+
+func (m *Migration) down(ctx context.Context) error {
+	migrations, err := m.getMigrations(DIRECTION_DOWN)
 	if err != nil {
 		return err
 	}
 
-	migrationVersion, err := getMigrationVersion(m.db)
+	migrationVersion, err := m.currentVersion(ctx)
 	if err != nil {
 		return err
 	}
 
-	q, err := newQuerier(m.db, m.path)
+	isApplied, err := m.getApplied(ctx, migrationVersion)
 	if err != nil {
-		return nil
-	}
-	var skipFile skipFileFunc = func(fileVersion int) bool {
-		return fileVersion > migrationVersion
+		return err
 	}
 
-	err = q.RunFileQueries(0, filesToRead, DIRECTION_DOWN, skipFile)
+	q := m.newQuerier()
+	err = q.RunMigrations(ctx, migrationVersion, 0, migrations, DIRECTION_DOWN, skipDown(isApplied, 0))
 	if err != nil {
 		return err
 	}
@@ -141,14 +309,20 @@ func (m *Migration) Down() error {
 // If specified version lower that current it'll run queries
 // with `down` action.
 //
-// Otherwise it'll return an error.
-func (m *Migration) Version(version int) error {
-	files, err := readDir(m.path)
-	if err != nil {
-		return err
-	}
+// Otherwise it'll return an error matching ErrNoChange.
+func (m *Migration) Version(version int64) error {
+	return m.VersionContext(context.Background(), version)
+}
 
-	migrationVersion, err := getMigrationVersion(m.db)
+// Same as Version. Migration stops and rolls back when ctx is done.
+func (m *Migration) VersionContext(ctx context.Context, version int64) error {
+	return m.withLock(ctx, func(m *Migration, ctx context.Context) error {
+		return m.version(ctx, version)
+	})
+}
+
+func (m *Migration) version(ctx context.Context, version int64) error {
+	migrationVersion, err := m.currentVersion(ctx)
 	if err != nil {
 		return err
 	}
@@ -157,46 +331,202 @@ func (m *Migration) Version(version int) error {
 	if version > migrationVersion {
 		direction = DIRECTION_UP
 	} else if version == migrationVersion {
-		return fmt.Errorf(ERROR_EQUAL_VERSION, version)
+		return &noChangeError{fmt.Sprintf(ERROR_EQUAL_VERSION, version)}
 	} else {
 		direction = DIRECTION_DOWN
 	}
-	filesToRead, err := getFilesWithDirection(files, direction)
+	migrations, err := m.getMigrations(direction)
 	if err != nil {
 		return err
 	}
+	if len(migrations) == 0 {
+		return fmt.Errorf("no %s migrations found", direction)
+	}
 
-	latestFileVersion := getVersionFromName(filesToRead[len(filesToRead)-1].Name())
-	if version > latestFileVersion && migrationVersion < latestFileVersion {
-		version = latestFileVersion
+	latestFileVersion := migrations[len(migrations)-1].version
+	if version > latestFileVersion {
+		if migrationVersion >= latestFileVersion && !m.outOfOrder {
+			return &noChangeError{ERROR_UP_TO_DATE}
+		}
+		// version without file is never set, out-of-order migrations
+		// keep the current version if it's greater
+		latestVersion := max(latestFileVersion, migrationVersion)
 		m.l.Warn(fmt.Sprintf("the selected version %d is greater than the latest version in files %d. Latest version will be set to %d.",
 			version,
 			latestFileVersion,
-			latestFileVersion,
+			latestVersion,
 		))
-	} else if migrationVersion == latestFileVersion && version > latestFileVersion {
-		return fmt.Errorf(ERROR_UP_TO_DATE)
+		version = latestVersion
 	}
 
-	var skipFile skipFileFunc
+	isApplied, err := m.getApplied(ctx, migrationVersion)
+	if err != nil {
+		return err
+	}
+	skipFile := skipDown(isApplied, version)
 	if direction == DIRECTION_UP {
-		skipFile = func(fileVersion int) bool {
-			return fileVersion <= migrationVersion || fileVersion > version
+		skipFile = skipUp(isApplied, version)
+	}
+
+	q := m.newQuerier()
+	err = q.RunMigrations(ctx, migrationVersion, version, migrations, direction, skipFile)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Apply next n pending migrations if n is positive or revert the last -n
+// applied migrations if n is negative. Migrations are counted in order of
+// versions of files, so the caller doesn't need to know version numbers.
+//
+// If there are fewer migrations than n, all of them are run.
+// Returns an error matching ErrNoChange if n is 0.
+func (m *Migration) Steps(n int) error {
+	return m.StepsContext(context.Background(), n)
+}
+
+// Same as Steps. Migration stops and rolls back when ctx is done.
+func (m *Migration) StepsContext(ctx context.Context, n int) error {
+	return m.withLock(ctx, func(m *Migration, ctx context.Context) error {
+		s, err := m.selectSteps(ctx, n)
+		if err != nil {
+			return err
 		}
+
+		q := m.newQuerier()
+		return q.runPending(ctx, s.current, s.version, s.pending, s.direction)
+	})
+}
+
+// Migrations selected by Steps
+type steps struct {
+	direction Direction
+	current   int64
+	// Version after pending migrations
+	version int64
+	pending []migration
+}
+
+func (m *Migration) selectSteps(ctx context.Context, n int) (*steps, error) {
+	if n == 0 {
+		return nil, &noChangeError{ERROR_ZERO_STEPS}
+	}
+	direction, target, count := DIRECTION_UP, int64(-1), n
+	if n < 0 {
+		direction, target, count = DIRECTION_DOWN, 0, -n
+	}
+
+	migrations, err := m.getMigrations(direction)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := m.currentVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	isApplied, err := m.getApplied(ctx, current)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &steps{direction: direction, current: current}
+	if direction == DIRECTION_UP {
+		s.pending, s.version, err = selectPending(current, migrations, direction, skipUp(isApplied, target))
 	} else {
-		skipFile = func(fileVersion int) bool {
-			return fileVersion <= version || fileVersion > migrationVersion
+		s.pending, s.version, err = selectPending(target, migrations, direction, skipDown(isApplied, target))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if count >= len(s.pending) {
+		return s, nil
+	}
+
+	if direction == DIRECTION_UP {
+		s.version = current
+		for _, mg := range s.pending[:count] {
+			s.version = max(s.version, mg.version)
 		}
+	} else {
+		// the latest migration which stays applied
+		s.version = s.pending[count].version
 	}
+	s.pending = s.pending[:count]
+	return s, nil
+}
 
-	q, err := newQuerier(m.db, m.path)
+// Current version or error wrapping ErrDirty. In read-only mode missing
+// version table means version 0.
+func (m *Migration) currentVersion(ctx context.Context) (int64, error) {
+	if !m.readOnly {
+		return getCleanMigrationVersion(ctx, m.db, m.dialect, m.table)
+	}
+	exists, err := tableExists(ctx, m.db, m.dialect, m.table)
+	if err != nil || !exists {
+		return 0, err
+	}
+	if columnExists(ctx, m.db, m.dialect, m.table, "dirty") {
+		return getCleanMigrationVersion(ctx, m.db, m.dialect, m.table)
+	}
+	// table of older version which isn't migrated to dirty column yet
+	var version int64
+	err = m.db.QueryRowContext(ctx, fmt.Sprintf(SELECT_VERSION_ONLY, quoteTable(m.dialect, m.table))).Scan(&version)
 	if err != nil {
-		return nil
+		return 0, fmt.Errorf("cannot read version of migrations: %w", err)
 	}
-	err = q.RunFileQueries(version, filesToRead, direction, skipFile)
+	return version, nil
+}
+
+func (m *Migration) newQuerier() *querier {
+	q := newQuerier(m.db, m.dialect, m.fsys, m.dir)
+	q.source = m.source
+	q.mode = m.txMode
+	q.l = m.l
+	q.table = m.table
+	q.historyTable = m.historyTable
+	return q
+}
+
+// Applied versions according to history table if out-of-order
+// migrations are allowed, otherwise every version up to current.
+func (m *Migration) getApplied(ctx context.Context, current int64) (appliedFunc, error) {
+	if !m.outOfOrder {
+		return appliedUpTo(current), nil
+	}
+	if m.readOnly {
+		exists, err := tableExists(ctx, m.db, m.dialect, m.historyTable)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return appliedUpTo(current), nil
+		}
+	}
+	return getAppliedVersions(ctx, m.db, m.dialect, m.historyTable, current)
+}
+
+// Set version and clear dirty flag without running any migration.
+//
+// Use it to recover after failed migration when database
+// was fixed manually.
+func (m *Migration) Force(version int64) error {
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	if version < 0 {
+		return fmt.Errorf("version should not be negative, got %d", version)
+	}
+
+	ctx := context.Background()
+	_, err := m.db.ExecContext(ctx, m.dialect.UpdateVersion(m.table), version)
 	if err != nil {
-		return err
+		return fmt.Errorf("cannot force version %d: %w", version, err)
 	}
+	m.l.Warn("Forced version", LOG_KEY_VERSION, version)
 
 	return nil
 }
@@ -1,10 +1,14 @@
 package pms
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"regexp"
 	"sort"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 )
@@ -16,8 +20,7 @@ func TestNewMigrator(t *testing.T) {
 	db, mock := newSQlMock(t)
 	defer db.Close()
 
-	mock.ExpectPing()
-	mock.ExpectExec(regexp.QuoteMeta(fmt.Sprintf(QUERY_CREATE_TABLE, TABLE_NAME))).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectNew(mock)
 
 	m, err := New(db, testDirname)
 	if err != nil {
@@ -36,10 +39,10 @@ func TestMigrationUp(t *testing.T) {
 	db, mock := newSQlMock(t)
 	defer db.Close()
 
-	mock.ExpectPing()
-	rows := mock.NewRows([]string{"version"}).AddRow(0)
-	mock.ExpectExec(regexp.QuoteMeta(fmt.Sprintf(QUERY_CREATE_TABLE, TABLE_NAME))).WillReturnResult(sqlmock.NewResult(0, 0))
-	mock.ExpectQuery(SELECT_VERSION).WillReturnRows(rows)
+	expectNew(mock)
+	rows := mock.NewRows([]string{"version", "dirty"}).AddRow(0, false)
+	expectLock(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(testDialect.SelectVersion(TABLE_NAME))).WillReturnRows(rows)
 
 	files := []TestFile{
 		{true, "1_users.up.sql", []byte("INSERT INTO users (name, email) VALUES ('Bobby', 'bob@mail.com')")},
@@ -47,11 +50,13 @@ func TestMigrationUp(t *testing.T) {
 		{true, "2_users.up.sql", []byte(`INSERT INTO users (name, email) VALUES ('Bobby', 'bob@mail.com'); INSERT INTO users (name, email) VALUES ('Bobby', 'bob@mail.com');`)},
 	}
 
+	expectSetDirty(mock)
 	mock.ExpectBegin()
 	f.CreateFiles(files)
-	f.CreateQueryMocks(files, mock)
-	mock.ExpectExec(fmt.Sprintf(QUERY_UPDATE_VERSION, TABLE_NAME, 2)).WillReturnResult(sqlmock.NewResult(1, 1))
+	f.CreateMigrationMocks(files, mock)
+	expectUpdateVersion(mock, 2)
 	mock.ExpectCommit()
+	expectUnlock(mock)
 
 	m, err := New(db, testDirname)
 	if err != nil {
@@ -66,6 +71,92 @@ func TestMigrationUp(t *testing.T) {
 	}
 }
 
+func TestMigrationUpPerMigration(t *testing.T) {
+	f := FileTester{t: t}
+	f.MakeTestDir()
+	defer f.RemoveAll()
+
+	db, mock := newSQlMock(t)
+	defer db.Close()
+
+	files := []TestFile{
+		{true, "1_users.up.sql", []byte("INSERT INTO users (name, email) VALUES ('Bobby', 'bob@mail.com')")},
+		{true, "2_users.up.sql", []byte("INSERT INTO users (name, email) VALUES ('Alice', 'alice@mail.com')")},
+	}
+	f.CreateFiles(files)
+
+	expectNew(mock)
+	expectLock(mock)
+	expectVersion(mock, 0, false)
+	for i, file := range files {
+		expectSetDirty(mock)
+		mock.ExpectBegin()
+		expectMigrationFile(mock, file)
+		expectUpdateVersion(mock, int64(i+1))
+		mock.ExpectCommit()
+	}
+	expectUnlock(mock)
+
+	m, err := New(db, testDirname, WithTransactionMode(TRANSACTION_PER_MIGRATION))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Up()
+	if err != nil {
+		t.Error(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMigrationUpNoTransaction(t *testing.T) {
+	f := FileTester{t: t}
+	f.MakeTestDir()
+	defer f.RemoveAll()
+
+	db, mock := newSQlMock(t)
+	defer db.Close()
+
+	files := []TestFile{
+		{true, "1_users.up.sql", []byte("CREATE TABLE users (name TEXT)")},
+		{true, "2_users.up.sql", []byte("-- pms:no-transaction\nCREATE INDEX CONCURRENTLY users_name ON users (name)")},
+		{true, "3_users.up.sql", []byte("ALTER TABLE users ADD COLUMN email TEXT")},
+	}
+	f.CreateFiles(files)
+
+	expectNew(mock)
+	expectLock(mock)
+	expectVersion(mock, 0, false)
+	expectSetDirty(mock)
+	mock.ExpectBegin()
+	expectMigrationFile(mock, files[0])
+	expectUpdateVersion(mock, 1)
+	mock.ExpectCommit()
+	// outside of transaction
+	expectSetDirty(mock)
+	expectMigrationFile(mock, files[1])
+	expectUpdateVersion(mock, 2)
+	expectSetDirty(mock)
+	mock.ExpectBegin()
+	expectMigrationFile(mock, files[2])
+	expectUpdateVersion(mock, 3)
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	m, err := New(db, testDirname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Up()
+	if err != nil {
+		t.Error(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestMigrationDown(t *testing.T) {
 	f := FileTester{t: t}
 	f.MakeTestDir()
@@ -74,10 +165,10 @@ func TestMigrationDown(t *testing.T) {
 	db, mock := newSQlMock(t)
 	defer db.Close()
 
-	mock.ExpectPing()
-	rows := mock.NewRows([]string{"version"}).AddRow(2)
-	mock.ExpectExec(regexp.QuoteMeta(fmt.Sprintf(QUERY_CREATE_TABLE, TABLE_NAME))).WillReturnResult(sqlmock.NewResult(0, 0))
-	mock.ExpectQuery(SELECT_VERSION).WillReturnRows(rows)
+	expectNew(mock)
+	rows := mock.NewRows([]string{"version", "dirty"}).AddRow(2, false)
+	expectLock(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(testDialect.SelectVersion(TABLE_NAME))).WillReturnRows(rows)
 
 	files := []TestFile{
 		{false, "1_users.up.sql", []byte("INSERT INTO users (name, email) VALUES ('Bobby', 'bob@mail.com')")},
@@ -90,11 +181,13 @@ func TestMigrationDown(t *testing.T) {
 		return files[i].name > files[j].name
 	})
 
+	expectSetDirty(mock)
 	mock.ExpectBegin()
 	f.CreateFiles(files)
-	f.CreateQueryMocks(files, mock)
-	mock.ExpectExec(fmt.Sprintf(QUERY_UPDATE_VERSION, TABLE_NAME, 0)).WillReturnResult(sqlmock.NewResult(1, 1))
+	f.CreateMigrationMocks(files, mock)
+	expectUpdateVersion(mock, 0)
 	mock.ExpectCommit()
+	expectUnlock(mock)
 
 	m, err := New(db, testDirname)
 	if err != nil {
@@ -118,10 +211,10 @@ func TestMigratorVersion(t *testing.T) {
 		db, mock := newSQlMock(t)
 		defer db.Close()
 
-		mock.ExpectPing()
-		rows := mock.NewRows([]string{"version"}).AddRow(1)
-		mock.ExpectExec(regexp.QuoteMeta(fmt.Sprintf(QUERY_CREATE_TABLE, TABLE_NAME))).WillReturnResult(sqlmock.NewResult(0, 0))
-		mock.ExpectQuery(SELECT_VERSION).WillReturnRows(rows)
+		expectNew(mock)
+		rows := mock.NewRows([]string{"version", "dirty"}).AddRow(1, false)
+		expectLock(mock)
+		mock.ExpectQuery(regexp.QuoteMeta(testDialect.SelectVersion(TABLE_NAME))).WillReturnRows(rows)
 
 		files := []TestFile{
 			{false, "1_users.up.sql", []byte("INSERT INTO users (name, email) VALUES ('Bobby', 'bob@mail.com')")},
@@ -136,11 +229,13 @@ func TestMigratorVersion(t *testing.T) {
 			return files[i].name < files[j].name
 		})
 
+		expectSetDirty(mock)
 		mock.ExpectBegin()
 		f.CreateFiles(files)
-		f.CreateQueryMocks(files, mock)
-		mock.ExpectExec(fmt.Sprintf(QUERY_UPDATE_VERSION, TABLE_NAME, 4)).WillReturnResult(sqlmock.NewResult(1, 1))
+		f.CreateMigrationMocks(files, mock)
+		expectUpdateVersion(mock, 4)
 		mock.ExpectCommit()
+		expectUnlock(mock)
 
 		m, err := New(db, testDirname)
 		if err != nil {
@@ -163,10 +258,10 @@ func TestMigratorVersion(t *testing.T) {
 		db, mock := newSQlMock(t)
 		defer db.Close()
 
-		mock.ExpectPing()
-		rows := mock.NewRows([]string{"version"}).AddRow(2)
-		mock.ExpectExec(regexp.QuoteMeta(fmt.Sprintf(QUERY_CREATE_TABLE, TABLE_NAME))).WillReturnResult(sqlmock.NewResult(0, 0))
-		mock.ExpectQuery(SELECT_VERSION).WillReturnRows(rows)
+		expectNew(mock)
+		rows := mock.NewRows([]string{"version", "dirty"}).AddRow(2, false)
+		expectLock(mock)
+		mock.ExpectQuery(regexp.QuoteMeta(testDialect.SelectVersion(TABLE_NAME))).WillReturnRows(rows)
 
 		files := []TestFile{
 			{false, "1_users.up.sql", []byte("INSERT INTO users (name, email) VALUES ('Bobby', 'bob@mail.com')")},
@@ -179,11 +274,13 @@ func TestMigratorVersion(t *testing.T) {
 			return files[i].name < files[j].name
 		})
 
+		expectSetDirty(mock)
 		mock.ExpectBegin()
 		f.CreateFiles(files)
-		f.CreateQueryMocks(files, mock)
-		mock.ExpectExec(fmt.Sprintf(QUERY_UPDATE_VERSION, TABLE_NAME, 1)).WillReturnResult(sqlmock.NewResult(1, 1))
+		f.CreateMigrationMocks(files, mock)
+		expectUpdateVersion(mock, 1)
 		mock.ExpectCommit()
+		expectUnlock(mock)
 
 		m, err := New(db, testDirname)
 		if err != nil {
@@ -206,10 +303,11 @@ func TestMigratorVersion(t *testing.T) {
 		db, mock := newSQlMock(t)
 		defer db.Close()
 
-		mock.ExpectPing()
-		rows := mock.NewRows([]string{"version"}).AddRow(2)
-		mock.ExpectExec(regexp.QuoteMeta(fmt.Sprintf(QUERY_CREATE_TABLE, TABLE_NAME))).WillReturnResult(sqlmock.NewResult(0, 0))
-		mock.ExpectQuery(SELECT_VERSION).WillReturnRows(rows)
+		expectNew(mock)
+		rows := mock.NewRows([]string{"version", "dirty"}).AddRow(2, false)
+		expectLock(mock)
+		mock.ExpectQuery(regexp.QuoteMeta(testDialect.SelectVersion(TABLE_NAME))).WillReturnRows(rows)
+		expectUnlock(mock)
 
 		files := []TestFile{
 			{false, "1_users.up.sql", []byte("INSERT INTO users (name, email) VALUES ('Bobby', 'bob@mail.com')")},
@@ -235,5 +333,198 @@ func TestMigratorVersion(t *testing.T) {
 		if err.Error() != fmt.Sprintf(ERROR_EQUAL_VERSION, 2) {
 			t.Errorf("not valid error message %q, expected %q", err, fmt.Sprintf(ERROR_EQUAL_VERSION, 2))
 		}
+		if !errors.Is(err, ErrNoChange) {
+			t.Errorf("expected ErrNoChange, got %v", err)
+		}
 	})
 }
+
+func TestMigrationUpFromFS(t *testing.T) {
+	db, mock := newSQlMock(t)
+	defer db.Close()
+
+	expectNew(mock)
+	rows := mock.NewRows([]string{"version", "dirty"}).AddRow(0, false)
+	expectLock(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(testDialect.SelectVersion(TABLE_NAME))).WillReturnRows(rows)
+
+	files := []TestFile{
+		{true, "1_users.up.sql", []byte("CREATE TABLE users(id SERIAL)")},
+		{false, "1_users.down.sql", []byte("DROP TABLE users")},
+		{true, "2_posts.up.sql", []byte("CREATE TABLE posts(id SERIAL)")},
+	}
+	fsys := fstest.MapFS{}
+	for _, file := range files {
+		fsys["migrations/"+file.name] = &fstest.MapFile{Data: file.content}
+	}
+
+	expectSetDirty(mock)
+	mock.ExpectBegin()
+	expectMigrationFile(mock, files[0])
+	expectMigrationFile(mock, files[2])
+	expectUpdateVersion(mock, 2)
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	m, err := NewFromFS(db, fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Up()
+	if err != nil {
+		t.Error(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewFromFSDirectoryNotFound(t *testing.T) {
+	db, _ := newSQlMock(t)
+	defer db.Close()
+
+	_, err := NewFromFS(db, fstest.MapFS{}, "migrations")
+	if err == nil {
+		t.Error("expected error for not existing directory")
+	}
+}
+
+func TestMigrationDirty(t *testing.T) {
+	newMigration := func(t *testing.T, fsys fstest.MapFS) (Migrator, sqlmock.Sqlmock) {
+		t.Helper()
+		db, mock := newSQlMock(t)
+		t.Cleanup(func() { db.Close() })
+
+		expectNew(mock)
+
+		m, err := NewFromFS(db, fsys, "migrations")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m, mock
+	}
+	fsys := fstest.MapFS{
+		"migrations/1_users.up.sql":   {Data: []byte("CREATE TABLE users(id SERIAL)")},
+		"migrations/1_users.down.sql": {Data: []byte("DROP TABLE users")},
+	}
+
+	t.Run("refuse to run while dirty", func(t *testing.T) {
+		m, mock := newMigration(t, fsys)
+		for i := 0; i < 3; i++ {
+			expectLock(mock)
+			expectVersion(mock, 1, true)
+			expectUnlock(mock)
+		}
+
+		if err := m.Up(); !errors.Is(err, ErrDirty) {
+			t.Errorf("Up: expected ErrDirty, got %v", err)
+		}
+		if err := m.Down(); !errors.Is(err, ErrDirty) {
+			t.Errorf("Down: expected ErrDirty, got %v", err)
+		}
+		if err := m.Version(0); !errors.Is(err, ErrDirty) {
+			t.Errorf("Version: expected ErrDirty, got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("failed migration is rolled back", func(t *testing.T) {
+		m, mock := newMigration(t, fsys)
+		expectLock(mock)
+		expectVersion(mock, 0, false)
+		expectSetDirty(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE users(id SERIAL)")).WillReturnError(errors.New("syntax error"))
+		mock.ExpectRollback()
+		// dirty flag is cleared
+		expectUpdateVersion(mock, 0)
+		expectUnlock(mock)
+
+		if err := m.Up(); err == nil {
+			t.Error("expected error")
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("dirty flag is kept without transactional DDL", func(t *testing.T) {
+		db, mock := newSQlMock(t)
+		defer db.Close()
+		expectNew(mock)
+		m, err := NewFromFS(db, fsys, "migrations", WithDialect(&nonTransactionalDialect{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectLock(mock)
+		expectVersion(mock, 0, false)
+		expectSetDirty(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE users(id SERIAL)")).WillReturnError(errors.New("syntax error"))
+		mock.ExpectRollback()
+		expectUnlock(mock)
+
+		if err := m.Up(); err == nil {
+			t.Error("expected error")
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("force", func(t *testing.T) {
+		m, mock := newMigration(t, fsys)
+		expectUpdateVersion(mock, 1)
+
+		if err := m.Force(1); err != nil {
+			t.Error(err)
+		}
+		if err := m.Force(-1); err == nil {
+			t.Error("expected error for negative version")
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// PostgreSQL dialect which DDL isn't rolled back like in MySQL
+type nonTransactionalDialect struct {
+	PostgresDialect
+}
+
+func (d *nonTransactionalDialect) TransactionalDDL() bool {
+	return false
+}
+
+func TestMigrationUpContext(t *testing.T) {
+	db, mock := newSQlMock(t)
+	defer db.Close()
+
+	expectNew(mock)
+	expectLock(mock)
+	expectVersion(mock, 0, false)
+	expectSetDirty(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE users(id SERIAL)")).WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	fsys := fstest.MapFS{
+		"migrations/1_users.up.sql": {Data: []byte("CREATE TABLE users(id SERIAL)")},
+	}
+	m, err := NewFromFS(db, fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = m.UpContext(ctx)
+	if err == nil {
+		t.Error("expected error after context deadline")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
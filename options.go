@@ -0,0 +1,80 @@
+package pms
+
+import "time"
+
+// Option to configure Migration in New and NewFromFS
+type Option func(*Migration)
+
+// Set how long to wait for the migration lock which is taken
+// by Up, Down and Version. Default is DEFAULT_LOCK_TIMEOUT.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(m *Migration) {
+		m.lockTimeout = timeout
+	}
+}
+
+// Set name of version table. History table is named after it with
+// HISTORY_TABLE_SUFFIX. Default is TABLE_NAME.
+func WithTableName(name string) Option {
+	return func(m *Migration) {
+		m.table = name
+		m.historyTable = name + HISTORY_TABLE_SUFFIX
+	}
+}
+
+// Set schema of version and history tables. By default they are
+// created in the current schema of connection. Schema should exist.
+//
+// It's a database for MySQL and a name of attached database for SQLite.
+func WithSchema(schema string) Option {
+	return func(m *Migration) {
+		m.schema = schema
+	}
+}
+
+// Set SQL dialect. By default it's detected by the driver of DB
+// and PostgreSQL is used for unknown drivers.
+func WithDialect(d Dialect) Option {
+	return func(m *Migration) {
+		m.dialect = d
+	}
+}
+
+// Allow to apply migrations with versions lower than current which
+// are not applied yet, for example merged from a long-lived branch.
+//
+// Applied migrations are tracked by history table. Versions lower than
+// the first one in history are treated as applied.
+func WithAllowOutOfOrder(allow bool) Option {
+	return func(m *Migration) {
+		m.outOfOrder = allow
+	}
+}
+
+// Set how migrations are wrapped in transactions. Default is
+// TRANSACTION_SINGLE which rolls back all migrations of the run if
+// one of them fails.
+func WithTransactionMode(mode TransactionMode) Option {
+	return func(m *Migration) {
+		m.txMode = mode
+	}
+}
+
+// Don't create or change version and history tables. Plan and PlanSteps
+// treat missing tables as version 0 without history, so the plan can be
+// checked before the first run. Up, Down, Version, Steps and Force
+// return ErrReadOnly.
+func WithReadOnly(readOnly bool) Option {
+	return func(m *Migration) {
+		m.readOnly = readOnly
+	}
+}
+
+// Set logger of migration events. Default logger writes colored text
+// to stdout, see NewTextLogger. Use NewSlogLogger or *slog.Logger for structured logs,
+// nil disables logging.
+func WithLogger(l Logger) Option {
+	return func(m *Migration) {
+		m.l = l
+	}
+}
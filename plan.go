@@ -0,0 +1,149 @@
+package pms
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Migration which will run according to Plan
+type PlannedMigration struct {
+	Version int64
+	Name    string
+	// Path of file or name of Go migration
+	Source string
+	// Content of file. Empty for Go migration.
+	SQL string
+	// File has `-- pms:no-transaction` directive
+	NoTransaction bool
+}
+
+// Ordered list of migrations to switch from the current version to target
+type Plan struct {
+	Direction Direction
+	// Version in database before migrations
+	From int64
+	// Version in database after migrations
+	To         int64
+	Migrations []PlannedMigration
+}
+
+// Write plan in human readable format. If withSQL is true content
+// of every file is written after its name.
+func (p *Plan) Write(w io.Writer, withSQL bool) error {
+	var s strings.Builder
+	if len(p.Migrations) == 0 {
+		s.WriteString(fmt.Sprintf("Nothing to migrate, version %d\n", p.From))
+		_, err := io.WriteString(w, s.String())
+		return err
+	}
+
+	s.WriteString(fmt.Sprintf("Migrate %s from version %d to %d:\n", p.Direction, p.From, p.To))
+	for i, mg := range p.Migrations {
+		s.WriteString(fmt.Sprintf("%d. %s", i+1, mg.Source))
+		if mg.NoTransaction {
+			s.WriteString(" (no transaction)")
+		}
+		s.WriteString("\n")
+		if withSQL && mg.SQL != "" {
+			s.WriteString(strings.TrimRight(mg.SQL, "\n"))
+			s.WriteString("\n\n")
+		}
+	}
+	_, err := io.WriteString(w, s.String())
+	return err
+}
+
+// Get migrations which will run to switch to target version
+// without executing them.
+//
+// Negative target means the latest version like Up, 0 means
+// reverting all migrations like Down. Otherwise it's the same as Version.
+func (m *Migration) Plan(target int64) (*Plan, error) {
+	ctx := context.Background()
+	migrationVersion, err := m.currentVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{Direction: DIRECTION_UP, From: migrationVersion, To: migrationVersion}
+	if target >= 0 && target < migrationVersion {
+		plan.Direction = DIRECTION_DOWN
+	}
+
+	migrations, err := m.getMigrations(plan.Direction)
+	if err != nil {
+		return nil, err
+	}
+
+	isApplied, err := m.getApplied(ctx, migrationVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	version := target
+	var skipFile skipFileFunc
+	switch {
+	case target < 0:
+		version = migrationVersion
+		skipFile = skipUp(isApplied, -1)
+	case target > migrationVersion:
+		if len(migrations) != 0 && target > migrations[len(migrations)-1].version {
+			target = migrations[len(migrations)-1].version
+		}
+		version = max(target, migrationVersion)
+		skipFile = skipUp(isApplied, target)
+	case target < migrationVersion:
+		skipFile = skipDown(isApplied, target)
+	default:
+		return plan, nil
+	}
+
+	pending, version, err := selectPending(version, migrations, plan.Direction, skipFile)
+	if err != nil {
+		return nil, err
+	}
+	return m.newPlan(plan.Direction, migrationVersion, version, pending)
+}
+
+// Get migrations which will run with Steps(n) without executing them.
+func (m *Migration) PlanSteps(n int) (*Plan, error) {
+	s, err := m.selectSteps(context.Background(), n)
+	if err != nil {
+		return nil, err
+	}
+	return m.newPlan(s.direction, s.current, s.version, s.pending)
+}
+
+// Plan to run pending migrations from version `from`. Version `to` is set
+// after them if there are any.
+func (m *Migration) newPlan(direction Direction, from, to int64, pending []migration) (*Plan, error) {
+	plan := &Plan{Direction: direction, From: from, To: from}
+	if len(pending) == 0 {
+		return plan, nil
+	}
+	if err := checkReversible(pending); err != nil {
+		return nil, err
+	}
+	plan.To = to
+
+	for _, mg := range pending {
+		planned := PlannedMigration{
+			Version:       mg.version,
+			Name:          mg.name,
+			Source:        mg.source(m.source),
+			NoTransaction: mg.noTransaction,
+		}
+		if !mg.isGo() {
+			content, err := getFileContent(m.fsys, m.dir, mg.fileName)
+			if err != nil {
+				return nil, err
+			}
+			planned.SQL = string(content)
+		}
+		plan.Migrations = append(plan.Migrations, planned)
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,189 @@
+package pms
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func assertPlan(t *testing.T, plan *Plan, direction Direction, from, to int64, sources ...string) {
+	t.Helper()
+	if plan.Direction != direction || plan.From != from || plan.To != to {
+		t.Errorf("got %s from %d to %d, expected %s from %d to %d", plan.Direction, plan.From, plan.To, direction, from, to)
+	}
+	if len(plan.Migrations) != len(sources) {
+		t.Fatalf("got %d migrations, expected %d", len(plan.Migrations), len(sources))
+	}
+	for i, source := range sources {
+		if plan.Migrations[i].Source != source {
+			t.Errorf("migration %d: got %q, expected %q", i, plan.Migrations[i].Source, source)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	db := newSQLiteDB(t)
+	m, err := NewFromFS(db, newSQLiteMigrations(), "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Register(4, "seed_users", func(ctx context.Context, tx *sql.Tx) error { return nil }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("up", func(t *testing.T) {
+		plan, err := m.Plan(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertPlan(t, plan, DIRECTION_UP, 0, 4,
+			"migrations/1_users.up.sql",
+			"migrations/2_posts.up.sql",
+			"migrations/3_comments.up.sql",
+			"go:4_seed_users",
+		)
+		if plan.Migrations[0].SQL != string(newSQLiteMigrations()["migrations/1_users.up.sql"].Data) {
+			t.Errorf("expected content of file, got %q", plan.Migrations[0].SQL)
+		}
+		if plan.Migrations[3].SQL != "" {
+			t.Errorf("expected empty SQL of go migration, got %q", plan.Migrations[3].SQL)
+		}
+		assertSQLiteVersion(t, db, 0, false)
+		assertSQLiteTables(t, db, map[string]bool{"users": false})
+	})
+
+	t.Run("version greater than latest", func(t *testing.T) {
+		plan, err := m.Plan(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertPlan(t, plan, DIRECTION_UP, 0, 4,
+			"migrations/1_users.up.sql",
+			"migrations/2_posts.up.sql",
+			"migrations/3_comments.up.sql",
+			"go:4_seed_users",
+		)
+	})
+
+	if err := m.Version(3); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("version down", func(t *testing.T) {
+		plan, err := m.Plan(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertPlan(t, plan, DIRECTION_DOWN, 3, 1,
+			"migrations/3_comments.down.sql",
+			"migrations/2_posts.down.sql",
+		)
+	})
+
+	t.Run("down", func(t *testing.T) {
+		plan, err := m.Plan(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertPlan(t, plan, DIRECTION_DOWN, 3, 0,
+			"migrations/3_comments.down.sql",
+			"migrations/2_posts.down.sql",
+			"migrations/1_users.down.sql",
+		)
+	})
+
+	t.Run("current version", func(t *testing.T) {
+		plan, err := m.Plan(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertPlan(t, plan, DIRECTION_UP, 3, 3)
+
+		var out bytes.Buffer
+		if err := plan.Write(&out, false); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != "Nothing to migrate, version 3\n" {
+			t.Errorf("got %q", out.String())
+		}
+	})
+
+	t.Run("write", func(t *testing.T) {
+		plan, err := m.Plan(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := plan.Write(&out, false); err != nil {
+			t.Fatal(err)
+		}
+		expected := "Migrate up from version 3 to 4:\n1. go:4_seed_users\n"
+		if out.String() != expected {
+			t.Errorf("got %q, expected %q", out.String(), expected)
+		}
+	})
+
+	assertSQLiteVersion(t, db, 3, false)
+}
+
+func TestPlanSteps(t *testing.T) {
+	db := newSQLiteDB(t)
+	m, err := NewFromFS(db, newSQLiteMigrations(), "migrations", WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := m.PlanSteps(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPlan(t, plan, DIRECTION_UP, 0, 2, "migrations/1_users.up.sql", "migrations/2_posts.up.sql")
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	plan, err = m.PlanSteps(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPlan(t, plan, DIRECTION_DOWN, 3, 2, "migrations/3_comments.down.sql")
+
+	plan, err = m.PlanSteps(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPlan(t, plan, DIRECTION_UP, 3, 3)
+	assertSQLiteVersion(t, db, 3, false)
+}
+
+func TestPlanReadOnly(t *testing.T) {
+	db := newSQLiteDB(t)
+	m, err := NewFromFS(db, newSQLiteMigrations(), "migrations", WithReadOnly(true), WithAllowOutOfOrder(true), WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteTables(t, db, map[string]bool{TABLE_NAME: false, HISTORY_TABLE_NAME: false})
+
+	plan, err := m.Plan(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPlan(t, plan, DIRECTION_UP, 0, 3, "migrations/1_users.up.sql", "migrations/2_posts.up.sql", "migrations/3_comments.up.sql")
+
+	plan, err = m.PlanSteps(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPlan(t, plan, DIRECTION_UP, 0, 1, "migrations/1_users.up.sql")
+
+	if err := m.Up(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Up: expected ErrReadOnly, got %v", err)
+	}
+	if err := m.Force(1); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Force: expected ErrReadOnly, got %v", err)
+	}
+	assertSQLiteTables(t, db, map[string]bool{TABLE_NAME: false, HISTORY_TABLE_NAME: false, "users": false})
+}
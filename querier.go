@@ -1,127 +1,445 @@
 package pms
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"io/fs"
-	"strings"
+	"time"
 )
 
-type skipFileFunc = func(fileVersion int) bool
+type skipFileFunc = func(fileVersion int64) bool
+
+// Reports whether migration with provided version is applied
+type appliedFunc = func(version int64) bool
+
+// Every version up to current is applied
+func appliedUpTo(current int64) appliedFunc {
+	return func(version int64) bool {
+		return version <= current
+	}
+}
+
+// Skip migrations which are applied or greater than target.
+// Negative target means the latest version.
+func skipUp(isApplied appliedFunc, target int64) skipFileFunc {
+	return func(fileVersion int64) bool {
+		return isApplied(fileVersion) || (target >= 0 && fileVersion > target)
+	}
+}
+
+// Skip migrations which are not applied or not greater than target
+func skipDown(isApplied appliedFunc, target int64) skipFileFunc {
+	return func(fileVersion int64) bool {
+		return fileVersion <= target || !isApplied(fileVersion)
+	}
+}
+
+// Select migrations to run in order of provided direction and the version
+// which will be set after them. For `up` direction version is raised to
+// the greatest version of selected migrations.
+func selectPending(version int64, migrations []migration, direction Direction, skipFile skipFileFunc) ([]migration, int64, error) {
+	var pending []migration
+	switch direction {
+	case DIRECTION_UP:
+		for _, mg := range migrations {
+			if skipFile(mg.version) {
+				continue
+			}
+			if mg.version > version {
+				version = mg.version
+			}
+			pending = append(pending, mg)
+		}
+	case DIRECTION_DOWN:
+		for i := len(migrations) - 1; i >= 0; i-- {
+			mg := migrations[i]
+			if skipFile(mg.version) {
+				continue
+			}
+			pending = append(pending, mg)
+		}
+	default:
+		return nil, version, fmt.Errorf("unhandled direction %q", direction)
+	}
+	return pending, version, nil
+}
 
 type querier struct {
-	tx   *sql.Tx
-	path string
-	l    Logger
+	db      DB
+	dialect Dialect
+	mode    TransactionMode
+	tx      *sql.Tx
+	fsys    fs.FS
+	dir     string
+	// Name of dir in logs
+	source string
+	l      Logger
+	// Connection of migrations which run outside of transaction,
+	// so session state is kept between statements of a file
+	conn *sql.Conn
+	// Names of version and history tables
+	table        string
+	historyTable string
+	hostname     string
+	username     string
 }
 
-// path - folder path
-func newQuerier(db DB, path string) (*querier, error) {
-	tx, err := db.Begin()
+// dir - folder path inside of fsys
+func newQuerier(db DB, dialect Dialect, fsys fs.FS, dir string) *querier {
+	hostname, username := getAppliedBy()
+	return &querier{
+		db:           db,
+		dialect:      dialect,
+		fsys:         fsys,
+		dir:          dir,
+		source:       dir,
+		l:            newEventLogger(),
+		table:        TABLE_NAME,
+		historyTable: HISTORY_TABLE_NAME,
+		hostname:     hostname,
+		username:     username,
+	}
+}
+
+// Begin transaction for queries
+func (q *querier) Begin(ctx context.Context) error {
+	tx, err := q.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("cannot begin transaction: %w", err)
 	}
-	return &querier{tx: tx, path: path, l: newEventLogger()}, nil
+	q.tx = tx
+	return nil
 }
 
-// Execute query and add to transaction
-func (q *querier) Add(path string, fileName string) error {
-	content, err := getFileContent(path, fileName)
+// Execute statements of file one by one, add to transaction and record it in history
+func (q *querier) Add(ctx context.Context, fileName string) error {
+	content, err := getFileContent(q.fsys, q.dir, fileName)
 	if err != nil {
 		return err
 	}
-	_, err = q.tx.Exec(string(content))
+	statements, err := splitStatements(string(content), q.dialect)
 	if err != nil {
-		q.tx.Rollback()
-		return fmt.Errorf(
-			"cannot execute file %q with content content: %q. \n%w",
-			fileName,
-			string(content),
-			err,
-		)
+		return fmt.Errorf("cannot split file %q into statements: %w", fileName, err)
+	}
+
+	start := time.Now()
+	for i, s := range statements {
+		_, err = q.Exec(ctx, s.query)
+		if err != nil {
+			return fmt.Errorf(
+				"cannot execute statement %d at line %d of file %q: %q. \n%w",
+				i+1,
+				s.line,
+				fileName,
+				s.query,
+				err,
+			)
+		}
+	}
+
+	err = q.addHistory(
+		ctx,
+		getVersionFromName(fileName),
+		getNameFromFileName(fileName),
+		getDirectionFromFileName(fileName),
+		checksum(content),
+		time.Since(start),
+	)
+	if err != nil {
+		return fmt.Errorf("cannot add file %q to history: %w", fileName, err)
 	}
 
 	return nil
 }
 
-// Execute query
-func (q *querier) Exec(query string, args ...any) (sql.Result, error) {
+// Run Go migration in transaction and record it in history
+func (q *querier) AddFunc(ctx context.Context, mg migration) error {
+	start := time.Now()
+	err := mg.fn(ctx, q.tx)
+	if err != nil {
+		return fmt.Errorf("cannot execute go migration %q: %w", goMigrationSource(mg.version, mg.name), err)
+	}
+
+	err = q.addHistory(ctx, mg.version, mg.name, mg.direction, goMigrationChecksum(mg.name), time.Since(start))
+	if err != nil {
+		return fmt.Errorf("cannot add go migration %q to history: %w", goMigrationSource(mg.version, mg.name), err)
+	}
+
+	return nil
+}
+
+// Insert a row about applied migration into history table
+func (q *querier) addHistory(ctx context.Context, version int64, name string, direction Direction, sum string, executionTime time.Duration) error {
+	_, err := q.Exec(
+		ctx,
+		insertHistoryQuery(q.dialect, q.historyTable),
+		version,
+		name,
+		string(direction),
+		sum,
+		time.Now().UTC(),
+		executionTime.Milliseconds(),
+		q.hostname,
+		q.username,
+	)
+	return err
+}
+
+// Execute query in transaction, in acquired connection if transaction isn't
+// started or directly in database
+func (q *querier) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if q.tx == nil && q.conn != nil {
+		return q.conn.ExecContext(ctx, query, args...)
+	}
+	if q.tx == nil {
+		return q.db.ExecContext(ctx, query, args...)
+	}
 	if len(args) != 0 {
-		return q.tx.Exec(query, args)
+		return q.tx.ExecContext(ctx, query, args...)
 	}
-	return q.tx.Exec(query)
+	return q.tx.ExecContext(ctx, query)
 }
 
-func (q *querier) Rollback() {
-	q.tx.Rollback()
+// Take one connection of pool for migrations which run outside of transaction
+func (q *querier) acquireConn(ctx context.Context) error {
+	if _, ok := q.db.(*lockConn); ok {
+		// migrations already run in one connection
+		return nil
+	}
+	conn, err := q.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot get connection: %w", err)
+	}
+	q.conn = conn
+	return nil
+}
+
+// Return acquired connection to pool
+func (q *querier) releaseConn() {
+	if q.conn == nil {
+		return
+	}
+	q.conn.Close()
+	q.conn = nil
+}
+
+func (q *querier) Rollback() error {
+	if q.tx == nil {
+		return nil
+	}
+	err := q.tx.Rollback()
+	q.tx = nil
+	return err
 }
 
 func (q *querier) Commit() error {
-	return q.tx.Commit()
+	err := q.tx.Commit()
+	q.tx = nil
+	return err
+}
+
+// Mark migrations as dirty outside of transaction.
+//
+// Flag is cleared with version update in the same transaction as migration
+// or after rollback of failed transaction. It stays in database if changes
+// of failed migration may be left applied.
+func (q *querier) markDirty(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, q.dialect.SetDirty(q.table))
+	if err != nil {
+		return fmt.Errorf("cannot mark migrations as dirty: %w", err)
+	}
+	return nil
 }
 
-// Run queries from files depends on provided direction.
+// Run SQL files and Go migrations depends on provided direction.
 //
+//   - ctx to cancel running queries
+//   - current version in database
 //   - version to switch
-//   - filesToRead files from specified folder
+//   - migrations ordered by version
 //   - direction(up or down)
-//   - skipFile function to skip files in loop
-func (q *querier) RunFileQueries(version int, filesToRead []fs.DirEntry, direction Direction, skipFile skipFileFunc) error {
-	switch direction {
-	case DIRECTION_UP:
-		for _, file := range filesToRead {
-			filenameChunks := strings.Split(file.Name(), ".")
-			name := filenameChunks[0]
-			fileVersion := getVersionFromName(name)
-			if skipFile(fileVersion) {
+//   - skipFile function to skip migrations in loop
+func (q *querier) RunMigrations(ctx context.Context, current, version int64, migrations []migration, direction Direction, skipFile skipFileFunc) error {
+	pending, version, err := selectPending(version, migrations, direction, skipFile)
+	if err != nil {
+		return err
+	}
+
+	return q.runPending(ctx, current, version, pending, direction)
+}
+
+// Run selected migrations in provided order and set version after them
+func (q *querier) runPending(ctx context.Context, current, version int64, pending []migration, direction Direction) error {
+	if len(pending) == 0 {
+		q.l.Info("Nothing to migrate")
+		return nil
+	}
+	if err := checkReversible(pending); err != nil {
+		return err
+	}
+
+	return q.runGroups(ctx, current, version, q.groupMigrations(pending), direction)
+}
+
+// Migrations which run together and update version after the last of them
+type migrationGroup struct {
+	migrations    []migration
+	inTransaction bool
+}
+
+// Split pending migrations into groups according to transaction mode.
+//
+// In TRANSACTION_SINGLE mode consecutive migrations share a transaction and
+// migrations with no-transaction directive run alone outside of it.
+// Otherwise every migration is a group. Go migrations always run in transaction.
+func (q *querier) groupMigrations(pending []migration) []migrationGroup {
+	var groups []migrationGroup
+	for _, mg := range pending {
+		inTransaction := !mg.noTransaction
+		switch q.mode {
+		case TRANSACTION_NONE:
+			inTransaction = mg.isGo()
+		case TRANSACTION_SINGLE, "":
+			if last := len(groups) - 1; inTransaction && last >= 0 && groups[last].inTransaction {
+				groups[last].migrations = append(groups[last].migrations, mg)
 				continue
 			}
-			if fileVersion > version {
-				version = fileVersion
-			}
-			err := q.Add(q.path, file.Name())
-			if err != nil {
-				q.l.Error("failed: ", strings.Join([]string{q.path, file.Name()}, "/"))
-				q.l.Error(err.Error())
-				q.l.Warn("Rolling back...")
-				return err
-			}
-			q.l.Info("Success:", strings.Join([]string{q.path, file.Name()}, "/"))
 		}
-	case DIRECTION_DOWN:
-		for i := len(filesToRead) - 1; i >= 0; i-- {
-			file := filesToRead[i]
-			filenameChunks := strings.Split(file.Name(), ".")
-			name := filenameChunks[0]
-			fileVersion := getVersionFromName(name)
-			if skipFile(fileVersion) {
-				continue
-			}
-			err := q.Add(q.path, file.Name())
-			if err != nil {
-				q.l.Error("failed: ", strings.Join([]string{q.path, file.Name()}, "/"))
-				q.l.Error(err.Error())
-				return err
+		groups = append(groups, migrationGroup{migrations: []migration{mg}, inTransaction: inTransaction})
+	}
+	return groups
+}
+
+// Run groups of migrations one by one. Version is updated after every group
+// in the same transaction, so database reflects progress if one of them fails.
+func (q *querier) runGroups(ctx context.Context, current, version int64, groups []migrationGroup, direction Direction) error {
+	// version in database before group
+	from := current
+	for i, group := range groups {
+		// version after group
+		groupVersion := version
+		for _, mg := range group.migrations {
+			current = max(current, mg.version)
+		}
+		if i < len(groups)-1 {
+			if direction == DIRECTION_UP {
+				groupVersion = current
+			} else {
+				groupVersion = groups[i+1].migrations[0].version
 			}
-			q.l.Info("Success:", strings.Join([]string{q.path, file.Name()}, "/"))
 		}
-	default:
-		return fmt.Errorf("unhandled direction %q", direction)
+
+		err := q.runGroup(ctx, group, from, groupVersion)
+		if err != nil {
+			return err
+		}
+		from = groupVersion
+	}
+	return nil
+}
+
+// Run migrations of group in transaction or in one connection and update
+// version from the current one to provided
+func (q *querier) runGroup(ctx context.Context, group migrationGroup, from, version int64) error {
+	err := q.markDirty(ctx)
+	if err != nil {
+		q.l.Error("Cannot start migrations", LOG_KEY_ERROR, err)
+		return err
 	}
 
-	if version != -1 {
-		_, err := q.Exec(fmt.Sprintf(QUERY_UPDATE_VERSION, TABLE_NAME, version))
+	if group.inTransaction {
+		err = q.Begin(ctx)
+	} else {
+		err = q.acquireConn(ctx)
+		defer q.releaseConn()
+	}
+	if err != nil {
+		q.l.Error("Cannot start migrations", LOG_KEY_ERROR, err)
+		return err
+	}
+
+	for _, mg := range group.migrations {
+		err = q.apply(ctx, mg)
 		if err != nil {
-			q.l.Error("cannot update version of migrations", err.Error())
-			q.Rollback()
+			q.abort(ctx, from)
 			return err
 		}
-		q.l.Warn(fmt.Sprintf("New version %d", version))
 	}
 
-	err := q.Commit()
+	err = q.commitVersion(ctx, version)
+	if err != nil {
+		q.abort(ctx, from)
+		return err
+	}
+	return nil
+}
+
+// Roll back transaction of failed group and restore version which was
+// before it. Dirty flag stays set if migrations ran outside of transaction,
+// rollback failed or DDL was committed implicitly(MySQL), because changes
+// of the group may be left in database.
+func (q *querier) abort(ctx context.Context, version int64) {
+	if q.tx == nil {
+		q.l.Warn("Migrations are marked as dirty, check the database and use Force to set the version")
+		return
+	}
+	err := q.Rollback()
+	if err != nil {
+		q.l.Warn("Cannot roll back. Migrations are marked as dirty, check the database and use Force to set the version", LOG_KEY_ERROR, err)
+		return
+	}
+	if !q.dialect.TransactionalDDL() {
+		q.l.Warn("Rolled back. Migrations are marked as dirty, check the database and use Force to set the version")
+		return
+	}
+	// context may be cancelled already, flag is cleared anyway
+	_, err = q.db.ExecContext(context.WithoutCancel(ctx), q.dialect.UpdateVersion(q.table), version)
+	if err != nil {
+		q.l.Warn("Rolled back. Cannot clear dirty flag, use Force to set the version", LOG_KEY_VERSION, version, LOG_KEY_ERROR, err)
+		return
+	}
+	q.l.Warn("Rolled back", LOG_KEY_VERSION, version)
+}
+
+// Run single migration
+func (q *querier) apply(ctx context.Context, mg migration) error {
+	start := time.Now()
+	var err error
+	if mg.isGo() {
+		err = q.AddFunc(ctx, mg)
+	} else {
+		err = q.Add(ctx, mg.fileName)
+	}
+	fields := []any{
+		LOG_KEY_VERSION, mg.version,
+		LOG_KEY_FILE, mg.source(q.source),
+		LOG_KEY_DIRECTION, string(mg.direction),
+		LOG_KEY_DURATION, time.Since(start),
+	}
+	if err != nil {
+		q.l.Error("Failed", append(fields, LOG_KEY_ERROR, err)...)
+		return err
+	}
+	q.l.Info("Success", fields...)
+	return nil
+}
+
+// Update version, clear dirty flag and commit transaction if it's started
+func (q *querier) commitVersion(ctx context.Context, version int64) error {
+	_, err := q.Exec(ctx, q.dialect.UpdateVersion(q.table), version)
+	if err != nil {
+		q.l.Error("Cannot update version of migrations", LOG_KEY_VERSION, version, LOG_KEY_ERROR, err)
+		return err
+	}
+	q.l.Warn("New version", LOG_KEY_VERSION, version)
+
+	if q.tx == nil {
+		return nil
+	}
+	err = q.Commit()
 	if err != nil {
-		q.l.Error("cannot commit queries", err.Error())
+		q.l.Error("Cannot commit queries", LOG_KEY_ERROR, err)
 		return err
 	}
 	return nil
@@ -1,28 +1,47 @@
 package pms
 
 import (
+	"context"
+	"os"
 	"testing"
 )
 
 func TestQuerier(t *testing.T) {
-	t.Run("NewQuerier", func(t *testing.T) {
+	t.Run("Begin", func(t *testing.T) {
 		db, mock := newSQlMock(t)
 		defer db.Close()
 		mock.ExpectBegin()
 
-		_, err := newQuerier(db, "")
+		q := newQuerier(db, testDialect, os.DirFS(testDirname), ".")
+		err := q.Begin(context.Background())
 		if err != nil {
 			t.Error(err)
 		}
 	})
 
+	t.Run("mark dirty", func(t *testing.T) {
+		db, mock := newSQlMock(t)
+		defer db.Close()
+		expectSetDirty(mock)
+
+		q := newQuerier(db, testDialect, os.DirFS(testDirname), ".")
+		err := q.markDirty(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
 	t.Run("Commit", func(t *testing.T) {
 		db, mock := newSQlMock(t)
 		defer db.Close()
 
 		mock.ExpectBegin()
 		mock.ExpectCommit()
-		q, err := newQuerier(db, "")
+		q := newQuerier(db, testDialect, os.DirFS(testDirname), ".")
+		err := q.Begin(context.Background())
 		if err != nil {
 			t.Error(err)
 		}
@@ -35,7 +54,8 @@ func TestQuerier(t *testing.T) {
 
 		mock.ExpectBegin()
 		mock.ExpectRollback()
-		q, err := newQuerier(db, "")
+		q := newQuerier(db, testDialect, os.DirFS(testDirname), ".")
+		err := q.Begin(context.Background())
 		if err != nil {
 			t.Error(err)
 		}
@@ -59,14 +79,15 @@ func TestQuerier(t *testing.T) {
 			{true, "2_users.down.sql", []byte("DELETE FROM users WHERE name='Bobby' AND email='bob@mail.com'")},
 		}
 		f.CreateFiles(files)
-		f.CreateQueryMocks(files, mock)
+		f.CreateMigrationMocks(files, mock)
 
-		q, err := newQuerier(db, "")
+		q := newQuerier(db, testDialect, os.DirFS(testDirname), ".")
+		err := q.Begin(context.Background())
 		if err != nil {
 			t.Error(err)
 		}
 		for _, file := range files {
-			q.Add(testDirname, file.name)
+			q.Add(context.Background(), file.name)
 		}
 	})
 }
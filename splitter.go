@@ -0,0 +1,294 @@
+package pms
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	// Lines around statement which shouldn't be split, for example body of procedure
+	DIRECTIVE_STATEMENT_BEGIN = "pms:statement-begin"
+	DIRECTIVE_STATEMENT_END   = "pms:statement-end"
+
+	DEFAULT_DELIMITER = ";"
+)
+
+var dollarQuoteRegexp = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// Single statement of migration file
+type statement struct {
+	query string
+	// Line of the first character of statement in file
+	line int
+}
+
+// Syntax of migration files which differs between dialects
+type SplitSyntax struct {
+	// $tag$ ... $tag$ strings and nested block comments of PostgreSQL
+	DollarQuotes bool
+	// `identifier` of MySQL and SQLite
+	Backticks bool
+	// # comments of MySQL
+	HashComments bool
+	// backslash escapes in strings of MySQL
+	BackslashEscapes bool
+	// DELIMITER command of MySQL client
+	DelimiterCommand bool
+	// BEGIN ... END; body of SQLite trigger
+	Triggers bool
+}
+
+// Dialect which files are split into statements with its own syntax.
+// Syntax of PostgreSQL is used for dialects which don't implement it.
+type SyntaxDialect interface {
+	SplitSyntax() SplitSyntax
+}
+
+func splitSyntaxOf(d Dialect) SplitSyntax {
+	if s, ok := d.(SyntaxDialect); ok {
+		return s.SplitSyntax()
+	}
+	return SplitSyntax{DollarQuotes: true}
+}
+
+// Split content of migration file into statements.
+//
+// Delimiters inside of string literals, quoted identifiers, comments and
+// dollar-quoted bodies are ignored. Lines between `-- pms:statement-begin`
+// and `-- pms:statement-end` are a single statement. For MySQL the
+// delimiter can be changed with `DELIMITER $$` line.
+func splitStatements(content string, d Dialect) ([]statement, error) {
+	syntax := splitSyntaxOf(d)
+	var (
+		statements []statement
+		delimiter  = DEFAULT_DELIMITER
+		// statement has something except spaces and comments
+		// which starts at codeStart, so leading comments are skipped
+		hasCode   bool
+		codeStart int
+		// first keywords of statement and depth of BEGIN/CASE ... END
+		// blocks to find the end of SQLite trigger
+		keywords []string
+		depth    int
+	)
+
+	lineOf := func(pos int) int {
+		return strings.Count(content[:pos], "\n") + 1
+	}
+	code := func(pos int) {
+		if !hasCode {
+			hasCode = true
+			codeStart = pos
+		}
+	}
+	flush := func(end int) {
+		keywords, depth = nil, 0
+		if !hasCode {
+			return
+		}
+		hasCode = false
+		query := content[codeStart:end]
+		trimmed := strings.TrimSpace(query)
+		if trimmed == "" {
+			return
+		}
+		offset := len(query) - len(strings.TrimLeftFunc(query, unicode.IsSpace))
+		statements = append(statements, statement{query: trimmed, line: lineOf(codeStart + offset)})
+	}
+
+	i := 0
+	for i < len(content) {
+		// commands which take the whole line
+		if i == 0 || content[i-1] == '\n' {
+			lineEnd := strings.IndexByte(content[i:], '\n')
+			if lineEnd == -1 {
+				lineEnd = len(content)
+			} else {
+				lineEnd += i
+			}
+			line := strings.TrimSpace(content[i:lineEnd])
+
+			if commentDirective(line) == DIRECTIVE_STATEMENT_BEGIN {
+				flush(i)
+				blockStart := lineEnd
+				end := -1
+				for pos := lineEnd; pos < len(content); {
+					next := strings.IndexByte(content[pos+1:], '\n')
+					if next == -1 {
+						next = len(content)
+					} else {
+						next += pos + 1
+					}
+					if commentDirective(strings.TrimSpace(content[pos:next])) == DIRECTIVE_STATEMENT_END {
+						end = pos
+						code(blockStart)
+						flush(end)
+						i = next
+						break
+					}
+					pos = next
+				}
+				if end == -1 {
+					return nil, fmt.Errorf("line %d: %q without %q", lineOf(i), "-- "+DIRECTIVE_STATEMENT_BEGIN, "-- "+DIRECTIVE_STATEMENT_END)
+				}
+				continue
+			}
+
+			if syntax.DelimiterCommand && len(line) > len("DELIMITER") && strings.EqualFold(line[:len("DELIMITER")], "DELIMITER") &&
+				(line[len("DELIMITER")] == ' ' || line[len("DELIMITER")] == '\t') {
+				flush(i)
+				delimiter = strings.TrimSpace(line[len("DELIMITER"):])
+				i = lineEnd
+				continue
+			}
+		}
+
+		c := content[i]
+		switch {
+		case strings.HasPrefix(content[i:], "--"), syntax.HashComments && c == '#':
+			end := strings.IndexByte(content[i:], '\n')
+			if end == -1 {
+				i = len(content)
+			} else {
+				i += end
+			}
+			continue
+
+		case strings.HasPrefix(content[i:], "/*"):
+			end, ok := commentEnd(content, i, syntax.DollarQuotes)
+			if !ok {
+				return nil, fmt.Errorf("line %d: unterminated comment", lineOf(i))
+			}
+			i = end
+			continue
+
+		case c == '\'' || c == '"' || (syntax.Backticks && c == '`'):
+			escapes := syntax.BackslashEscapes && c != '`'
+			// E'...' strings of PostgreSQL
+			if syntax.DollarQuotes && c == '\'' && i > 0 && (content[i-1] == 'E' || content[i-1] == 'e') &&
+				(i == 1 || !isIdentChar(content[i-2])) {
+				escapes = true
+			}
+			end, ok := quotedEnd(content, i, c, escapes)
+			if !ok {
+				return nil, fmt.Errorf("line %d: unterminated quoted string", lineOf(i))
+			}
+			code(i)
+			i = end
+			continue
+
+		case syntax.DollarQuotes && c == '$' && (i == 0 || !isIdentChar(content[i-1])):
+			if tag := dollarQuoteRegexp.FindString(content[i:]); tag != "" {
+				end := strings.Index(content[i+len(tag):], tag)
+				if end == -1 {
+					return nil, fmt.Errorf("line %d: unterminated dollar-quoted string %s", lineOf(i), tag)
+				}
+				code(i)
+				i += len(tag) + end + len(tag)
+				continue
+			}
+
+		case strings.HasPrefix(content[i:], delimiter):
+			if syntax.Triggers && delimiter == DEFAULT_DELIMITER && depth > 0 {
+				break
+			}
+			flush(i)
+			i += len(delimiter)
+			continue
+
+		case syntax.Triggers && isIdentChar(c) && (i == 0 || !isIdentChar(content[i-1])):
+			end := i + 1
+			for end < len(content) && isIdentChar(content[end]) {
+				end++
+			}
+			word := strings.ToUpper(content[i:end])
+			if len(keywords) < 3 {
+				keywords = append(keywords, word)
+			}
+			if isTrigger(keywords) {
+				switch word {
+				case "BEGIN", "CASE":
+					depth++
+				case "END":
+					depth = max(depth-1, 0)
+				}
+			}
+			code(i)
+			i = end
+			continue
+		}
+		if !unicode.IsSpace(rune(c)) {
+			code(i)
+		}
+		i++
+	}
+	flush(len(content))
+
+	return statements, nil
+}
+
+// Text of `-- text` comment line or empty string
+func commentDirective(line string) string {
+	comment, ok := strings.CutPrefix(line, "--")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(comment)
+}
+
+// Position after the end of block comment which starts at i.
+// Comments of PostgreSQL can be nested.
+func commentEnd(content string, i int, nested bool) (int, bool) {
+	depth := 0
+	for j := i; j+1 < len(content); j++ {
+		switch {
+		case content[j] == '/' && content[j+1] == '*' && (nested || depth == 0):
+			depth++
+			j++
+		case content[j] == '*' && content[j+1] == '/':
+			depth--
+			j++
+			if depth == 0 {
+				return j + 1, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Position after closing quote of string which starts at i
+func quotedEnd(content string, i int, quote byte, backslashEscapes bool) (int, bool) {
+	for j := i + 1; j < len(content); j++ {
+		switch content[j] {
+		case '\\':
+			if backslashEscapes {
+				j++
+			}
+		case quote:
+			// doubled quote is escaped quote
+			if j+1 < len(content) && content[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1, true
+		}
+	}
+	return 0, false
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// SQLite trigger has statements inside of BEGIN ... END
+func isTrigger(keywords []string) bool {
+	if len(keywords) < 2 || keywords[0] != "CREATE" {
+		return false
+	}
+	if keywords[1] == "TEMP" || keywords[1] == "TEMPORARY" {
+		return len(keywords) > 2 && keywords[2] == "TRIGGER"
+	}
+	return keywords[1] == "TRIGGER"
+}
@@ -0,0 +1,205 @@
+package pms
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name     string
+		dialect  Dialect
+		content  string
+		expected []statement
+	}{
+		{
+			name:    "simple statements",
+			dialect: &PostgresDialect{},
+			content: "CREATE TABLE users (id INT);\n\nINSERT INTO users VALUES (1);\n",
+			expected: []statement{
+				{"CREATE TABLE users (id INT)", 1},
+				{"INSERT INTO users VALUES (1)", 3},
+			},
+		},
+		{
+			name:    "last statement without delimiter",
+			dialect: &PostgresDialect{},
+			content: "SELECT 1; SELECT 2",
+			expected: []statement{
+				{"SELECT 1", 1},
+				{"SELECT 2", 1},
+			},
+		},
+		{
+			name:    "delimiters in strings and comments",
+			dialect: &PostgresDialect{},
+			content: "-- first; comment\nINSERT INTO t VALUES ('a;b', 'it''s;', \"c;d\");\n/* block; \n comment */ SELECT 1;\n-- trailing comment;",
+			expected: []statement{
+				{"INSERT INTO t VALUES ('a;b', 'it''s;', \"c;d\")", 2},
+				{"SELECT 1", 4},
+			},
+		},
+		{
+			name:    "postgres dollar quotes",
+			dialect: &PostgresDialect{},
+			content: "CREATE FUNCTION f() RETURNS INT AS $$\nBEGIN\n  RETURN 1;\nEND;\n$$ LANGUAGE plpgsql;\nDO $body$ BEGIN PERFORM 1; END $body$;\nSELECT $1;",
+			expected: []statement{
+				{"CREATE FUNCTION f() RETURNS INT AS $$\nBEGIN\n  RETURN 1;\nEND;\n$$ LANGUAGE plpgsql", 1},
+				{"DO $body$ BEGIN PERFORM 1; END $body$", 6},
+				{"SELECT $1", 7},
+			},
+		},
+		{
+			name:    "postgres escape strings",
+			dialect: &PostgresDialect{},
+			content: "SELECT E'a\\';b'; SELECT 'c\\'; SELECT 2",
+			expected: []statement{
+				{"SELECT E'a\\';b'", 1},
+				{"SELECT 'c\\'", 1},
+				{"SELECT 2", 1},
+			},
+		},
+		{
+			name:    "mysql delimiter",
+			dialect: &MySQLDialect{},
+			content: "DELIMITER $$\nCREATE PROCEDURE p()\nBEGIN\n  SELECT 1;\nEND$$\nDELIMITER ;\nCALL p();",
+			expected: []statement{
+				{"CREATE PROCEDURE p()\nBEGIN\n  SELECT 1;\nEND", 2},
+				{"CALL p()", 7},
+			},
+		},
+		{
+			name:    "mysql quotes and comments",
+			dialect: &MySQLDialect{},
+			content: "# comment;\nINSERT INTO `a;b` VALUES ('x\\';y');\nSELECT 1;",
+			expected: []statement{
+				{"INSERT INTO `a;b` VALUES ('x\\';y')", 2},
+				{"SELECT 1", 3},
+			},
+		},
+		{
+			name:    "sqlite trigger",
+			dialect: &SQLiteDialect{},
+			content: "CREATE TRIGGER t AFTER INSERT ON users\nBEGIN\n  UPDATE users SET name = 'x';\n  DELETE FROM posts;\nEND;\nSELECT 1;",
+			expected: []statement{
+				{"CREATE TRIGGER t AFTER INSERT ON users\nBEGIN\n  UPDATE users SET name = 'x';\n  DELETE FROM posts;\nEND", 1},
+				{"SELECT 1", 6},
+			},
+		},
+		{
+			name:    "sqlite trigger with case",
+			dialect: &SQLiteDialect{},
+			content: "CREATE TRIGGER t AFTER INSERT ON users BEGIN SELECT CASE WHEN 1 THEN 2 END; UPDATE users SET name = 'x'; END;",
+			expected: []statement{
+				{"CREATE TRIGGER t AFTER INSERT ON users BEGIN SELECT CASE WHEN 1 THEN 2 END; UPDATE users SET name = 'x'; END", 1},
+			},
+		},
+		{
+			name:    "sqlite trigger with comment after end",
+			dialect: &SQLiteDialect{},
+			content: "CREATE TEMP TRIGGER t AFTER INSERT ON users\nBEGIN\n  DELETE FROM posts; -- end;\nEND -- done\n;\nBEGIN TRANSACTION;\nSELECT 1;",
+			expected: []statement{
+				{"CREATE TEMP TRIGGER t AFTER INSERT ON users\nBEGIN\n  DELETE FROM posts; -- end;\nEND -- done", 1},
+				{"BEGIN TRANSACTION", 6},
+				{"SELECT 1", 7},
+			},
+		},
+		{
+			name:    "statement begin and end",
+			dialect: &PostgresDialect{},
+			content: "SELECT 1;\n-- pms:statement-begin\nCREATE RULE r AS ON INSERT TO t DO ALSO (SELECT 1; SELECT 2);\n-- pms:statement-end\nSELECT 2;",
+			expected: []statement{
+				{"SELECT 1", 1},
+				{"CREATE RULE r AS ON INSERT TO t DO ALSO (SELECT 1; SELECT 2);", 3},
+				{"SELECT 2", 5},
+			},
+		},
+		{
+			name:     "only comments",
+			dialect:  &PostgresDialect{},
+			content:  "-- pms:no-transaction\n-- nothing;\n",
+			expected: nil,
+		},
+		{
+			name:    "comment after delimiter",
+			dialect: &PostgresDialect{},
+			content: "SELECT 1; -- note\nSELEC 2;",
+			expected: []statement{
+				{"SELECT 1", 1},
+				{"SELEC 2", 2},
+			},
+		},
+		{
+			name:    "postgres nested comments",
+			dialect: &PostgresDialect{},
+			content: "/* outer /* inner; */ still comment; */ SELECT 1;\nSELECT 2;",
+			expected: []statement{
+				{"SELECT 1", 1},
+				{"SELECT 2", 2},
+			},
+		},
+		{
+			name:    "mysql comments are not nested",
+			dialect: &MySQLDialect{},
+			content: "/* outer /* inner */ SELECT 1;",
+			expected: []statement{
+				{"SELECT 1", 1},
+			},
+		},
+		{
+			name:     "syntax of custom dialect",
+			dialect:  &hashCommentsDialect{},
+			content:  "# comment;\nSELECT 1;",
+			expected: []statement{{"SELECT 1", 2}},
+		},
+		{
+			name:     "mysql only comments",
+			dialect:  &MySQLDialect{},
+			content:  "SELECT 1;\n# nothing;\n/* end */",
+			expected: []statement{{"SELECT 1", 1}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			statements, err := splitStatements(test.content, test.dialect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(statements, test.expected) {
+				t.Errorf("got %q, expected %q", statements, test.expected)
+			}
+		})
+	}
+}
+
+// Dialect with PostgreSQL queries and # comments
+type hashCommentsDialect struct {
+	PostgresDialect
+}
+
+func (d *hashCommentsDialect) SplitSyntax() SplitSyntax {
+	return SplitSyntax{DollarQuotes: true, HashComments: true}
+}
+
+func TestSplitStatementsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"unterminated string", "SELECT 1;\nSELECT 'a;"},
+		{"unterminated comment", "SELECT 1; /* comment"},
+		{"unterminated nested comment", "SELECT 1; /* outer /* inner */"},
+		{"unterminated dollar quote", "DO $$ BEGIN END;"},
+		{"statement begin without end", "-- pms:statement-begin\nSELECT 1;"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := splitStatements(test.content, &PostgresDialect{})
+			if err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
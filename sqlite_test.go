@@ -0,0 +1,714 @@
+package pms
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func newSQLiteDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "pms.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newSQLiteMigrations() fstest.MapFS {
+	return fstest.MapFS{
+		"migrations/1_users.up.sql":      {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);")},
+		"migrations/1_users.down.sql":    {Data: []byte("DROP TABLE users;")},
+		"migrations/2_posts.up.sql":      {Data: []byte("CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL REFERENCES users(id)); CREATE INDEX posts_user_id ON posts (user_id);")},
+		"migrations/2_posts.down.sql":    {Data: []byte("DROP INDEX posts_user_id; DROP TABLE posts;")},
+		"migrations/3_comments.up.sql":   {Data: []byte("CREATE TABLE comments (id INTEGER PRIMARY KEY, body TEXT);")},
+		"migrations/3_comments.down.sql": {Data: []byte("DROP TABLE comments;")},
+	}
+}
+
+func assertSQLiteVersion(t *testing.T, db *sql.DB, expected int64, expectedDirty bool) {
+	t.Helper()
+	version, dirty, err := getMigrationVersion(context.Background(), db, &SQLiteDialect{}, TABLE_NAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != expected || dirty != expectedDirty {
+		t.Errorf("got version %d dirty %t, expected version %d dirty %t", version, dirty, expected, expectedDirty)
+	}
+}
+
+func assertSQLiteTables(t *testing.T, db *sql.DB, tables map[string]bool) {
+	t.Helper()
+	for table, expected := range tables {
+		exists, err := tableExists(context.Background(), db, &SQLiteDialect{}, table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != expected {
+			t.Errorf("table %q exists %t, expected %t", table, exists, expected)
+		}
+	}
+}
+
+func countSQLiteRows(t *testing.T, db *sql.DB, table string) int {
+	t.Helper()
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM " + quoteTable(&SQLiteDialect{}, table)).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	db := newSQLiteDB(t)
+	m, err := NewFromFS(db, newSQLiteMigrations(), "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := m.(*Migration).dialect; d.Name() != "sqlite" {
+		t.Fatalf("expected sqlite dialect, got %q", d.Name())
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 3, false)
+	assertSQLiteTables(t, db, map[string]bool{"users": true, "posts": true, "comments": true})
+
+	if err := m.Version(1); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 1, false)
+	assertSQLiteTables(t, db, map[string]bool{"users": true, "posts": false, "comments": false})
+	if err := m.Down(); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 0, false)
+	assertSQLiteTables(t, db, map[string]bool{"users": false})
+
+	if count := countSQLiteRows(t, db, HISTORY_TABLE_NAME); count != 6 {
+		t.Errorf("expected 6 rows in history, got %d", count)
+	}
+
+	// tables are not created twice
+	if _, err := NewFromFS(db, newSQLiteMigrations(), "migrations"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSQLiteParentPath(t *testing.T) {
+	dir := t.TempDir()
+	for name, file := range newSQLiteMigrations() {
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(name)), file.Data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join(dir, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	db := newSQLiteDB(t)
+	m, err := New(db, "..", WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 3, false)
+}
+
+func TestSQLiteFailedMigration(t *testing.T) {
+	db := newSQLiteDB(t)
+	fsys := newSQLiteMigrations()
+	fsys["migrations/2_posts.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE posts (id INTEGER PRIMARY KEY); CREATE TABL broken;")}
+
+	m, err := NewFromFS(db, fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Up(); err == nil {
+		t.Fatal("expected error of broken migration")
+	}
+	// DDL and dirty flag are rolled back
+	assertSQLiteVersion(t, db, 0, false)
+	assertSQLiteTables(t, db, map[string]bool{"users": false, "posts": false})
+
+	if err := m.Up(); err == nil || errors.Is(err, ErrDirty) {
+		t.Errorf("expected error of broken migration, got %v", err)
+	}
+
+	fsys["migrations/2_posts.up.sql"] = newSQLiteMigrations()["migrations/2_posts.up.sql"]
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 3, false)
+}
+
+func TestSQLiteValidate(t *testing.T) {
+	db := newSQLiteDB(t)
+	fsys := newSQLiteMigrations()
+
+	m, err := NewFromFS(db, fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	fsys["migrations/1_users.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);")}
+	var checksumErr *ChecksumError
+	if err := m.Validate(); !errors.As(err, &checksumErr) || len(checksumErr.Mismatches) != 1 {
+		t.Errorf("expected one checksum mismatch, got %v", err)
+	}
+}
+
+// Single connection of db which Lock expects
+func newSQLiteConn(t *testing.T, db *sql.DB) DB {
+	t.Helper()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &lockConn{conn: conn}
+}
+
+func TestSQLiteLock(t *testing.T) {
+	db := newSQLiteDB(t)
+	d := &SQLiteDialect{}
+	ctx := context.Background()
+
+	unlock, err := d.Lock(ctx, newSQLiteConn(t, db), TABLE_NAME, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.Lock(ctx, newSQLiteConn(t, db), TABLE_NAME, 200*time.Millisecond)
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("expected ErrLockTimeout, got %v", err)
+	}
+
+	if err := unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err = d.Lock(ctx, newSQLiteConn(t, db), TABLE_NAME, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unlock(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSQLiteStaleLock(t *testing.T) {
+	db := newSQLiteDB(t)
+	m, err := NewFromFS(db, newSQLiteMigrations(), "migrations", WithLockTimeout(200*time.Millisecond), WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &SQLiteDialect{}
+	lockTable := quoteTable(d, TABLE_NAME+"_lock")
+	if _, err := db.Exec(fmt.Sprintf(SQLITE_CREATE_LOCK_TABLE, lockTable)); err != nil {
+		t.Fatal(err)
+	}
+	hostname, _ := getAppliedBy()
+
+	t.Run("process of another host", func(t *testing.T) {
+		_, err := db.Exec(fmt.Sprintf(SQLITE_INSERT_LOCK, lockTable), time.Now().UTC(), "other-host", os.Getpid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.Up()
+		if !errors.Is(err, ErrLockTimeout) || !strings.Contains(err.Error(), `on "other-host"`) {
+			t.Fatalf("expected ErrLockTimeout with holder of lock, got %v", err)
+		}
+
+		// Force doesn't release lock which may be held by running process
+		if err := m.Force(0); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Up(); !errors.Is(err, ErrLockTimeout) {
+			t.Fatalf("expected ErrLockTimeout, got %v", err)
+		}
+	})
+
+	t.Run("running process", func(t *testing.T) {
+		_, err := db.Exec(fmt.Sprintf(SQLITE_INSERT_LOCK, lockTable), time.Now().UTC(), hostname, os.Getpid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Up(); !errors.Is(err, ErrLockTimeout) {
+			t.Fatalf("expected ErrLockTimeout, got %v", err)
+		}
+	})
+
+	t.Run("crashed process", func(t *testing.T) {
+		// greater than maximum pid of Linux, so process doesn't exist
+		_, err := db.Exec(fmt.Sprintf(SQLITE_INSERT_LOCK, lockTable), time.Now().UTC(), hostname, math.MaxInt32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Up(); err != nil {
+			t.Fatal(err)
+		}
+		assertSQLiteVersion(t, db, 3, false)
+		if count := countSQLiteRows(t, db, TABLE_NAME+"_lock"); count != 0 {
+			t.Errorf("expected lock to be released, got %d rows", count)
+		}
+	})
+}
+
+func TestSQLiteInMemory(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// every connection opens a new in-memory database
+	db.SetMaxOpenConns(1)
+
+	m, err := NewFromFS(db, newSQLiteMigrations(), "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 3, false)
+}
+
+func TestSQLiteTimestampVersions(t *testing.T) {
+	db := newSQLiteDB(t)
+	fsys := fstest.MapFS{
+		"migrations/20261017120000_users.up.sql":   {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);")},
+		"migrations/20261017120000_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"migrations/20261018093000_posts.up.sql":   {Data: []byte("CREATE TABLE posts (id INTEGER PRIMARY KEY);")},
+		"migrations/20261018093000_posts.down.sql": {Data: []byte("DROP TABLE posts;")},
+	}
+	m, err := NewFromFS(db, fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 20261018093000, false)
+
+	if err := m.Version(20261017120000); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 20261017120000, false)
+	assertSQLiteTables(t, db, map[string]bool{"users": true, "posts": false})
+}
+
+func TestSQLiteOutOfOrder(t *testing.T) {
+	db := newSQLiteDB(t)
+	fsys := newSQLiteMigrations()
+	fsys["migrations/5_tags.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE tags (id INTEGER PRIMARY KEY);")}
+	fsys["migrations/5_tags.down.sql"] = &fstest.MapFile{Data: []byte("DROP TABLE tags;")}
+	branch := fsys["migrations/3_comments.up.sql"]
+	branchDown := fsys["migrations/3_comments.down.sql"]
+	delete(fsys, "migrations/3_comments.up.sql")
+	delete(fsys, "migrations/3_comments.down.sql")
+
+	m, err := NewFromFS(db, fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 5, false)
+
+	// migration merged from a long-lived branch
+	fsys["migrations/3_comments.up.sql"] = branch
+	fsys["migrations/3_comments.down.sql"] = branchDown
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteTables(t, db, map[string]bool{"comments": false})
+
+	status, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := status.Migrations[2]; s.Version != 3 || s.Applied {
+		t.Errorf("expected version 3 not applied, got %+v", s)
+	}
+
+	m, err = NewFromFS(db, fsys, "migrations", WithAllowOutOfOrder(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := m.Plan(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Migrations) != 1 || plan.Migrations[0].Version != 3 || plan.To != 5 {
+		t.Errorf("expected plan with version 3 to version 5, got %+v", plan)
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 5, false)
+	assertSQLiteTables(t, db, map[string]bool{"comments": true, "tags": true})
+
+	if err := m.Version(2); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 2, false)
+	assertSQLiteTables(t, db, map[string]bool{"users": true, "posts": true, "comments": false, "tags": false})
+}
+
+func TestSQLiteOutOfOrderBeforeHistory(t *testing.T) {
+	db := newSQLiteDB(t)
+	m, err := NewFromFS(db, newSQLiteMigrations(), "migrations", WithAllowOutOfOrder(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Version(2); err != nil {
+		t.Fatal(err)
+	}
+
+	// version 1 was applied before history table was created
+	_, err = db.Exec("DELETE FROM " + HISTORY_TABLE_NAME + " WHERE version = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 3, false)
+	if count := countSQLiteRows(t, db, HISTORY_TABLE_NAME); count != 2 {
+		t.Errorf("expected version 1 not to run again, got %d rows in history", count)
+	}
+}
+
+func TestSQLiteTransactionModes(t *testing.T) {
+	broken := &fstest.MapFile{Data: []byte("CREATE TABLE posts (id INTEGER PRIMARY KEY); CREATE TABL broken;")}
+
+	tests := []struct {
+		mode    TransactionMode
+		version int64
+		dirty   bool
+		tables  map[string]bool
+	}{
+		{TRANSACTION_SINGLE, 0, false, map[string]bool{"users": false, "posts": false}},
+		{TRANSACTION_PER_MIGRATION, 1, false, map[string]bool{"users": true, "posts": false}},
+		// first statement of broken file is not rolled back
+		{TRANSACTION_NONE, 1, true, map[string]bool{"users": true, "posts": true}},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.mode), func(t *testing.T) {
+			db := newSQLiteDB(t)
+			fsys := newSQLiteMigrations()
+			fsys["migrations/2_posts.up.sql"] = broken
+
+			m, err := NewFromFS(db, fsys, "migrations", WithTransactionMode(test.mode))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Up(); err == nil {
+				t.Fatal("expected error of broken migration")
+			}
+			assertSQLiteVersion(t, db, test.version, test.dirty)
+			assertSQLiteTables(t, db, test.tables)
+			if err := m.Up(); test.dirty && !errors.Is(err, ErrDirty) {
+				t.Errorf("expected ErrDirty, got %v", err)
+			}
+		})
+	}
+
+	t.Run("down per migration", func(t *testing.T) {
+		db := newSQLiteDB(t)
+		fsys := newSQLiteMigrations()
+		m, err := NewFromFS(db, fsys, "migrations", WithTransactionMode(TRANSACTION_PER_MIGRATION))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Up(); err != nil {
+			t.Fatal(err)
+		}
+		assertSQLiteVersion(t, db, 3, false)
+
+		fsys["migrations/1_users.down.sql"] = &fstest.MapFile{Data: []byte("DROP TABL users;")}
+		if err := m.Down(); err == nil {
+			t.Fatal("expected error of broken migration")
+		}
+		assertSQLiteVersion(t, db, 1, false)
+		assertSQLiteTables(t, db, map[string]bool{"users": true, "posts": false, "comments": false})
+	})
+
+	t.Run("unknown mode", func(t *testing.T) {
+		_, err := NewFromFS(newSQLiteDB(t), newSQLiteMigrations(), "migrations", WithTransactionMode("nested"))
+		if !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("expected ErrInvalidOption, got %v", err)
+		}
+	})
+}
+
+func TestSQLiteNoTransactionDirective(t *testing.T) {
+	for _, mode := range []TransactionMode{TRANSACTION_SINGLE, TRANSACTION_PER_MIGRATION} {
+		t.Run(string(mode), func(t *testing.T) {
+			db := newSQLiteDB(t)
+			fsys := newSQLiteMigrations()
+			fsys["migrations/4_vacuum.up.sql"] = &fstest.MapFile{Data: []byte("VACUUM;")}
+			fsys["migrations/4_vacuum.down.sql"] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+
+			m, err := NewFromFS(db, fsys, "migrations", WithTransactionMode(mode))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Up(); err == nil {
+				t.Fatal("expected error of VACUUM in transaction")
+			}
+			if err := m.Force(3); err != nil {
+				t.Fatal(err)
+			}
+
+			fsys["migrations/4_vacuum.up.sql"] = &fstest.MapFile{Data: []byte("-- pms:no-transaction\nVACUUM;")}
+			if err := m.Up(); err != nil {
+				t.Fatal(err)
+			}
+			assertSQLiteVersion(t, db, 4, false)
+
+			status, err := m.Status()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := status.Migrations[3]; !s.Applied || s.AppliedAt == nil {
+				t.Errorf("expected version 4 recorded in history, got %+v", s)
+			}
+		})
+	}
+}
+
+func TestSQLiteNoTransactionSession(t *testing.T) {
+	for _, mode := range []TransactionMode{TRANSACTION_SINGLE, TRANSACTION_NONE} {
+		t.Run(string(mode), func(t *testing.T) {
+			db := newSQLiteDB(t)
+			// every statement outside of acquired connection gets a new one
+			db.SetMaxIdleConns(0)
+			fsys := newSQLiteMigrations()
+			fsys["migrations/4_tmp.up.sql"] = &fstest.MapFile{Data: []byte(
+				"-- pms:no-transaction\n" +
+					"CREATE TEMP TABLE tmp (id INTEGER);\n" +
+					"INSERT INTO tmp (id) VALUES (1);\n" +
+					"INSERT INTO users (id, name) SELECT id, 'tmp' FROM tmp;",
+			)}
+			fsys["migrations/4_tmp.down.sql"] = &fstest.MapFile{Data: []byte("DELETE FROM users;")}
+
+			m, err := NewFromFS(db, fsys, "migrations", WithTransactionMode(mode), WithLogger(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Up(); err != nil {
+				t.Fatal(err)
+			}
+			assertSQLiteVersion(t, db, 4, false)
+			if count := countSQLiteRows(t, db, "users"); count != 1 {
+				t.Errorf("got %d users, expected 1", count)
+			}
+		})
+	}
+}
+
+func TestSQLiteTableName(t *testing.T) {
+	db := newSQLiteDB(t)
+
+	m, err := NewFromFS(db, newSQLiteMigrations(), "migrations", WithTableName("app_migrations"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Version(2); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteTables(t, db, map[string]bool{
+		"app_migrations":         true,
+		"app_migrations_history": true,
+		"app_migrations_lock":    true,
+		TABLE_NAME:               false,
+		HISTORY_TABLE_NAME:       false,
+	})
+
+	version, _, err := getMigrationVersion(context.Background(), db, &SQLiteDialect{}, "app_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Errorf("got version %d, expected 2", version)
+	}
+	if count := countSQLiteRows(t, db, "app_migrations_history"); count != 2 {
+		t.Errorf("got %d history rows, expected 2", count)
+	}
+	if err := m.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	t.Run("empty name", func(t *testing.T) {
+		_, err := NewFromFS(db, newSQLiteMigrations(), "migrations", WithTableName(""))
+		if !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("expected ErrInvalidOption, got %v", err)
+		}
+	})
+}
+
+func TestSQLiteSchema(t *testing.T) {
+	db := newSQLiteDB(t)
+	// attached database exists only in the connection
+	db.SetMaxOpenConns(1)
+	_, err := db.Exec("ATTACH DATABASE ? AS billing", filepath.Join(t.TempDir(), "billing.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewFromFS(db, newSQLiteMigrations(), "migrations", WithSchema("billing"), WithTableName("app_migrations"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteTables(t, db, map[string]bool{
+		"billing.app_migrations":         true,
+		"billing.app_migrations_history": true,
+		"app_migrations":                 false,
+		TABLE_NAME:                       false,
+	})
+	if count := countSQLiteRows(t, db, "billing.app_migrations_history"); count != 3 {
+		t.Errorf("got %d history rows, expected 3", count)
+	}
+
+	t.Run("qualified table name", func(t *testing.T) {
+		_, err := NewFromFS(db, newSQLiteMigrations(), "migrations", WithTableName("billing.migrations"))
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestSQLiteSteps(t *testing.T) {
+	db := newSQLiteDB(t)
+	m, err := NewFromFS(db, newSQLiteMigrations(), "migrations", WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 1, false)
+	assertSQLiteTables(t, db, map[string]bool{"users": true, "posts": false})
+
+	if err := m.Steps(5); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 3, false)
+	assertSQLiteTables(t, db, map[string]bool{"posts": true, "comments": true})
+
+	if err := m.Steps(-2); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 1, false)
+	assertSQLiteTables(t, db, map[string]bool{"users": true, "posts": false, "comments": false})
+
+	if err := m.Steps(-1); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 0, false)
+	assertSQLiteTables(t, db, map[string]bool{"users": false})
+
+	if err := m.Steps(0); !errors.Is(err, ErrNoChange) {
+		t.Errorf("expected ErrNoChange, got %v", err)
+	}
+}
+
+func TestSQLiteStepsOutOfOrder(t *testing.T) {
+	db := newSQLiteDB(t)
+	fsys := newSQLiteMigrations()
+	branch := fsys["migrations/2_posts.up.sql"]
+	delete(fsys, "migrations/2_posts.up.sql")
+
+	m, err := NewFromFS(db, fsys, "migrations", WithAllowOutOfOrder(true), WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 3, false)
+
+	// migration merged from a long-lived branch
+	fsys["migrations/2_posts.up.sql"] = branch
+	fsys["migrations/4_tags.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE tags (id INTEGER PRIMARY KEY);")}
+	fsys["migrations/4_tags.down.sql"] = &fstest.MapFile{Data: []byte("DROP TABLE tags;")}
+
+	if err := m.Steps(1); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 3, false)
+	assertSQLiteTables(t, db, map[string]bool{"posts": true, "tags": false})
+
+	if err := m.Steps(1); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 4, false)
+	assertSQLiteTables(t, db, map[string]bool{"tags": true})
+}
+
+func TestSQLiteOutOfOrderVersionAboveFiles(t *testing.T) {
+	db := newSQLiteDB(t)
+	fsys := newSQLiteMigrations()
+	branch := fsys["migrations/2_posts.up.sql"]
+	delete(fsys, "migrations/2_posts.up.sql")
+
+	m, err := NewFromFS(db, fsys, "migrations", WithAllowOutOfOrder(true), WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 3, false)
+
+	// migration merged from a long-lived branch
+	fsys["migrations/2_posts.up.sql"] = branch
+
+	if err := m.Version(100); err != nil {
+		t.Fatal(err)
+	}
+	assertSQLiteVersion(t, db, 3, false)
+	assertSQLiteTables(t, db, map[string]bool{"posts": true})
+}
@@ -0,0 +1,154 @@
+package pms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// State of single migration returned by Status
+type MigrationStatus struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+	// Migration is registered Go function
+	Go      bool `json:"go"`
+	Applied bool `json:"applied"`
+	// Time of the last `up` run from history table. Nil if unknown.
+	AppliedAt   *time.Time `json:"applied_at,omitempty"`
+	MissingUp   bool       `json:"missing_up"`
+	MissingDown bool       `json:"missing_down"`
+}
+
+// State of database returned by Status
+type Status struct {
+	Version    int64             `json:"version"`
+	Dirty      bool              `json:"dirty"`
+	Migrations []MigrationStatus `json:"migrations"`
+}
+
+// Write status as a table
+func (s *Status) Write(w io.Writer) error {
+	var b strings.Builder
+	state := "clean"
+	if s.Dirty {
+		state = "dirty"
+	}
+	b.WriteString(fmt.Sprintf("Version %d (%s)\n", s.Version, state))
+
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED\tAPPLIED AT\tMISSING")
+	for _, mg := range s.Migrations {
+		name := mg.Name
+		if mg.Go {
+			name = goMigrationSource(mg.Version, mg.Name)
+		}
+		applied := "no"
+		if mg.Applied {
+			applied = "yes"
+		}
+		appliedAt := "-"
+		if mg.AppliedAt != nil {
+			appliedAt = mg.AppliedAt.Format(time.DateTime)
+		}
+		var missing []string
+		if mg.MissingUp {
+			missing = append(missing, string(DIRECTION_UP))
+		}
+		if mg.MissingDown {
+			missing = append(missing, string(DIRECTION_DOWN))
+		}
+		if len(missing) == 0 {
+			missing = append(missing, "-")
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", mg.Version, name, applied, appliedAt, strings.Join(missing, ","))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Write status as JSON
+func (s *Status) WriteJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(s)
+}
+
+// Get current version and state of every migration file and Go migration.
+//
+// Migration is applied according to history table, so migrations with
+// versions lower than current which were skipped are reported as not applied.
+func (m *Migration) Status() (*Status, error) {
+	ctx := context.Background()
+	migrationVersion, dirty, err := getMigrationVersion(ctx, m.db, m.dialect, m.table)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := getAppliedMigrations(ctx, m.db, m.dialect, m.historyTable)
+	if err != nil {
+		return nil, err
+	}
+	isApplied, err := getAppliedVersions(ctx, m.db, m.dialect, m.historyTable, migrationVersion)
+	if err != nil {
+		return nil, err
+	}
+	appliedAt := make(map[int64]time.Time, len(applied))
+	for _, a := range applied {
+		if !a.appliedAt.IsZero() {
+			appliedAt[a.version] = a.appliedAt
+		}
+	}
+
+	byVersion := make(map[int64]*MigrationStatus)
+	get := func(version int64, name string) *MigrationStatus {
+		s, ok := byVersion[version]
+		if !ok {
+			s = &MigrationStatus{Version: version, Name: name, MissingUp: true, MissingDown: true}
+			byVersion[version] = s
+		}
+		return s
+	}
+
+	for _, direction := range []Direction{DIRECTION_UP, DIRECTION_DOWN} {
+		migrations, err := m.getMigrations(direction)
+		if err != nil {
+			return nil, err
+		}
+		for _, mg := range migrations {
+			s := get(mg.version, mg.name)
+			s.Go = mg.isGo()
+			if direction == DIRECTION_UP {
+				s.MissingUp = false
+			} else if !mg.isIrreversible() {
+				s.MissingDown = false
+			}
+		}
+	}
+	// Go migration without down function
+	for version, g := range m.goMigrations {
+		get(version, g.name).Go = true
+	}
+
+	status := &Status{Version: migrationVersion, Dirty: dirty}
+	for version, s := range byVersion {
+		s.Applied = isApplied(version)
+		if t, ok := appliedAt[version]; ok && s.Applied {
+			s.AppliedAt = &t
+		}
+		status.Migrations = append(status.Migrations, *s)
+	}
+	sort.Slice(status.Migrations, func(i, j int) bool {
+		return status.Migrations[i].Version < status.Migrations[j].Version
+	})
+
+	return status, nil
+}
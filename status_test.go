@@ -0,0 +1,75 @@
+package pms
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+	db := newSQLiteDB(t)
+	fsys := newSQLiteMigrations()
+	delete(fsys, "migrations/3_comments.down.sql")
+	fsys["migrations/5_tags.down.sql"] = fsys["migrations/1_users.down.sql"]
+
+	m, err := NewFromFS(db, fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Register(4, "seed_users", func(ctx context.Context, tx *sql.Tx) error { return nil }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now().Add(-time.Minute)
+	if err := m.Version(2); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != 2 || status.Dirty {
+		t.Errorf("got version %d dirty %t, expected version 2 clean", status.Version, status.Dirty)
+	}
+
+	expected := []MigrationStatus{
+		{Version: 1, Name: "users", Applied: true},
+		{Version: 2, Name: "posts", Applied: true},
+		{Version: 3, Name: "comments", MissingDown: true},
+		{Version: 4, Name: "seed_users", Go: true, MissingDown: true},
+		{Version: 5, Name: "tags", MissingUp: true},
+	}
+	if len(status.Migrations) != len(expected) {
+		t.Fatalf("got %d migrations, expected %d", len(status.Migrations), len(expected))
+	}
+	for i, e := range expected {
+		got := status.Migrations[i]
+		if got.Applied && (got.AppliedAt == nil || got.AppliedAt.Before(before)) {
+			t.Errorf("version %d: expected time of apply, got %v", got.Version, got.AppliedAt)
+		}
+		if !got.Applied && got.AppliedAt != nil {
+			t.Errorf("version %d: expected empty time of apply, got %v", got.Version, got.AppliedAt)
+		}
+		got.AppliedAt = nil
+		if got != e {
+			t.Errorf("got %+v, expected %+v", got, e)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := status.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 7 || lines[0] != "Version 2 (clean)" || !strings.HasPrefix(lines[1], "VERSION") {
+		t.Fatalf("unexpected table:\n%s", out.String())
+	}
+	if fields := strings.Fields(lines[5]); fields[1] != "go:4_seed_users" || fields[2] != "no" || fields[3] != "-" || fields[4] != "down" {
+		t.Errorf("unexpected row of go migration %q", lines[5])
+	}
+}
@@ -1,17 +1,33 @@
 package pms
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"io/fs"
 	"os"
+	"os/user"
+	"path"
 	"sort"
 	"strings"
+	"time"
 )
 
-const (
-	SELECT_VERSION = "SELECT version FROM migrations"
-)
+// Migration which is applied according to history table
+type appliedMigration struct {
+	version   int64
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// Latest record of migration in history table
+type historyRecord struct {
+	appliedMigration
+	direction Direction
+}
 
 func getFilesWithDirection(files []fs.DirEntry, inc Direction) ([]fs.DirEntry, error) {
 	var filesToRead []fs.DirEntry
@@ -36,38 +52,51 @@ func getFilesWithDirection(files []fs.DirEntry, inc Direction) ([]fs.DirEntry, e
 	return filesToRead, nil
 }
 
-func getFileContent(path string, fileName string) ([]byte, error) {
-	file, err := fs.ReadFile(os.DirFS(path), fileName)
+func getFileContent(fsys fs.FS, dir string, fileName string) ([]byte, error) {
+	file, err := fs.ReadFile(fsys, path.Join(dir, fileName))
 	if err != nil {
 		return nil, fmt.Errorf("cannot find file: %w", err)
 	}
 	return file, nil
 }
 
-func getVersionFromName(name string) int {
-	var version int
+func getVersionFromName(name string) int64 {
+	var version int64
 	for _, s := range name {
 		if s == '_' {
 			break
 		}
 		if s >= '0' && s <= '9' {
-			version = version*10 + int(s-'0')
+			version = version*10 + int64(s-'0')
 		}
 	}
 
 	return version
 }
 
-func readDir(path string) ([]fs.DirEntry, error) {
-	if files, err := os.ReadDir(path); err != nil {
-		return nil, fmt.Errorf("directory %q not found. Error: %w", path, err)
+func readDir(fsys fs.FS, dir string) ([]fs.DirEntry, error) {
+	if files, err := fs.ReadDir(fsys, dir); err != nil {
+		return nil, fmt.Errorf("directory %q not found. Error: %w", dir, err)
 	} else {
 		return files, err
 	}
 }
 
-func createTable(db DB, tableName string) error {
-	_, err := db.Exec(fmt.Sprintf(QUERY_CREATE_TABLE, tableName))
+// Create version table and insert initial version
+func createTable(ctx context.Context, db DB, d Dialect, tableName string) error {
+	for _, query := range d.CreateVersionTable(tableName) {
+		_, err := db.ExecContext(ctx, query)
+
+		if err != nil {
+			return fmt.Errorf("cannot create table %q: %w", tableName, err)
+		}
+	}
+
+	return nil
+}
+
+func createHistoryTable(ctx context.Context, db DB, d Dialect, tableName string) error {
+	_, err := db.ExecContext(ctx, d.CreateHistoryTable(tableName))
 
 	if err != nil {
 		return fmt.Errorf("cannot create table %q: %w", tableName, err)
@@ -76,20 +105,227 @@ func createTable(db DB, tableName string) error {
 	return nil
 }
 
-func getMigrationVersion(db DB) (int, error) {
-	var migrationVersion int
-	row := db.QueryRow(SELECT_VERSION)
-	switch err := row.Scan(&migrationVersion); err {
+// Name of migration from file name. For example "users" from "1_users.up.sql"
+func getNameFromFileName(fileName string) string {
+	name := strings.Split(fileName, ".")[0]
+	if _, after, found := strings.Cut(name, "_"); found {
+		return after
+	}
+	return ""
+}
+
+// Direction of migration from file name. For example "up" from "1_users.up.sql"
+func getDirectionFromFileName(fileName string) Direction {
+	filenameChunks := strings.Split(fileName, ".")
+	if len(filenameChunks) < 3 {
+		return ""
+	}
+	return Direction(filenameChunks[1])
+}
+
+// Hex encoded SHA-256 of migration content
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Host and user of the current process to store them in history
+func getAppliedBy() (hostname string, username string) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	} else if username = os.Getenv("USER"); username == "" {
+		username = "unknown"
+	}
+
+	return hostname, username
+}
+
+func addDirtyColumn(ctx context.Context, db DB, d Dialect, tableName string) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(QUERY_ADD_DIRTY_COLUMN, quoteTable(d, tableName)))
+
+	if err != nil {
+		return fmt.Errorf("cannot add column %q to table %q: %w", "dirty", tableName, err)
+	}
+
+	return nil
+}
+
+// Returns current version and dirty flag
+func getMigrationVersion(ctx context.Context, db DB, d Dialect, tableName string) (int64, bool, error) {
+	var (
+		migrationVersion int64
+		dirty            bool
+	)
+	row := db.QueryRowContext(ctx, d.SelectVersion(tableName))
+	switch err := row.Scan(&migrationVersion, &dirty); err {
 	case sql.ErrNoRows:
-		return 0, fmt.Errorf("now rows found in table `migrations`")
+		return 0, false, fmt.Errorf("now rows found in table %q", tableName)
 	case nil:
-		return migrationVersion, nil
+		return migrationVersion, dirty, nil
+	default:
+		return 0, false, fmt.Errorf("cannot read version of migrations: %w", err)
+	}
+}
+
+// Returns current version or error wrapping ErrDirty if the last migration failed
+func getCleanMigrationVersion(ctx context.Context, db DB, d Dialect, tableName string) (int64, error) {
+	migrationVersion, dirty, err := getMigrationVersion(ctx, db, d, tableName)
+	if err != nil {
+		return 0, err
+	}
+	if dirty {
+		return 0, fmt.Errorf("%w: "+ERROR_DIRTY, ErrDirty, migrationVersion)
+	}
+	return migrationVersion, nil
+}
+
+// Latest record of every version in history table
+func getHistory(ctx context.Context, db DB, d Dialect, tableName string) (map[int64]historyRecord, error) {
+	rows, err := db.QueryContext(ctx, selectHistoryQuery(d, tableName))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read history from %q: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	latest := make(map[int64]historyRecord)
+	for rows.Next() {
+		var (
+			r         historyRecord
+			direction string
+			appliedAt any
+		)
+		if err := rows.Scan(&r.version, &r.name, &direction, &r.checksum, &appliedAt); err != nil {
+			return nil, fmt.Errorf("cannot read history from %q: %w", tableName, err)
+		}
+		r.direction = Direction(direction)
+		r.appliedAt = parseTime(appliedAt)
+		latest[r.version] = r
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read history from %q: %w", tableName, err)
+	}
+
+	return latest, nil
+}
+
+// Returns migrations which are currently applied ordered by version.
+func getAppliedMigrations(ctx context.Context, db DB, d Dialect, tableName string) ([]appliedMigration, error) {
+	history, err := getHistory(ctx, db, d, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []appliedMigration
+	for _, r := range history {
+		if r.direction == DIRECTION_UP {
+			applied = append(applied, r.appliedMigration)
+		}
+	}
+	sort.Slice(applied, func(i, j int) bool {
+		return applied[i].version < applied[j].version
+	})
+
+	return applied, nil
+}
+
+// Set of applied versions from history table.
+//
+// Versions lower than the first version in history are treated as applied
+// because they were migrated before history table was created. If history is
+// empty every version up to current is applied.
+func getAppliedVersions(ctx context.Context, db DB, d Dialect, tableName string, current int64) (appliedFunc, error) {
+	history, err := getHistory(ctx, db, d, tableName)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return appliedUpTo(current), nil
+	}
+
+	baseline := int64(-1)
+	for version := range history {
+		if baseline == -1 || version < baseline {
+			baseline = version
+		}
+	}
+	return func(version int64) bool {
+		if r, ok := history[version]; ok {
+			return r.direction == DIRECTION_UP
+		}
+		return version < baseline && version <= current
+	}, nil
+}
+
+// Column name isn't quoted, because SQLite treats unknown quoted identifier as string
+func columnExists(ctx context.Context, db DB, d Dialect, tableName string, column string) bool {
+	rows, err := db.QueryContext(ctx, "SELECT "+column+" FROM "+quoteTable(d, tableName)+" WHERE 1 = 0")
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+func tableExists(ctx context.Context, db DB, d Dialect, tableName string) (bool, error) {
+	var count int
+	query, args := d.TableExists(tableName)
+	err := db.QueryRowContext(ctx, query, args...).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("cannot check if table %q exists: %w", tableName, err)
+	}
+	return count > 0, nil
+}
+
+// Layouts of time returned as text by drivers. For example MySQL without parseTime=true.
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// Convert value of time column to time.Time. Returns zero time if format is unknown.
+func parseTime(v any) time.Time {
+	var s string
+	switch t := v.(type) {
+	case time.Time:
+		return t
+	case []byte:
+		s = string(t)
+	case string:
+		s = t
 	default:
-		panic(err)
+		return time.Time{}
+	}
+	for _, layout := range timeLayouts {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			return parsed
+		}
 	}
+	return time.Time{}
 }
 
-func tableExists(db DB, tableName string) bool {
-	_, tableCheck := db.Query("SELECT * FROM " + tableName + ";")
-	return tableCheck == nil
+// Check that header of file has `-- pms:<directive>` comment.
+// Header is comments and empty lines before the first statement.
+func hasDirective(content []byte, directive string) bool {
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		comment, ok := strings.CutPrefix(line, "--")
+		if !ok {
+			return false
+		}
+		if strings.TrimSpace(comment) == directive {
+			return true
+		}
+	}
+	return false
 }
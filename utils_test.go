@@ -1,7 +1,9 @@
 package pms
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
@@ -24,15 +26,51 @@ func newSQlMock(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
 func TestCreateTable(t *testing.T) {
 	db, mock := newSQlMock(t)
 
-	mock.ExpectExec(regexp.QuoteMeta(fmt.Sprintf(QUERY_CREATE_TABLE, "test_table"))).WillReturnError(nil).WillReturnResult(sqlmock.NewResult(0, 0))
-	err := createTable(db, "test_table")
+	for _, query := range testDialect.CreateVersionTable("test_table") {
+		mock.ExpectExec(regexp.QuoteMeta(query)).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	err := createTable(context.Background(), db, testDialect, "test_table")
+	if err != nil {
+		t.Error(err)
+	}
+}
+func TestCreateHistoryTable(t *testing.T) {
+	db, mock := newSQlMock(t)
+
+	mock.ExpectExec(regexp.QuoteMeta(testDialect.CreateHistoryTable("test_history"))).WillReturnResult(sqlmock.NewResult(0, 0))
+	err := createHistoryTable(context.Background(), db, testDialect, "test_history")
 	if err != nil {
 		t.Error(err)
 	}
 }
+
+func TestGetNameFromFileName(t *testing.T) {
+	fileNames := []struct {
+		name      string
+		fileName  string
+		direction Direction
+	}{
+		{"users", "1_users.up.sql", DIRECTION_UP},
+		{"add_posts", "100_add_posts.down.sql", DIRECTION_DOWN},
+		{"", "2.up.sql", DIRECTION_UP},
+		{"", "", ""},
+	}
+
+	for _, data := range fileNames {
+		t.Run(data.fileName, func(t *testing.T) {
+			if name := getNameFromFileName(data.fileName); name != data.name {
+				t.Errorf("not expected name %q, expected %q", name, data.name)
+			}
+			if direction := getDirectionFromFileName(data.fileName); direction != data.direction {
+				t.Errorf("not expected direction %q, expected %q", direction, data.direction)
+			}
+		})
+	}
+}
+
 func TestGetVersionFromName(t *testing.T) {
 	fileNames := []struct {
-		version int
+		version int64
 		name    string
 	}{
 		{1, "1_test.up.sql"},
@@ -40,6 +78,7 @@ func TestGetVersionFromName(t *testing.T) {
 		{1006, "100h6_test.up.sql"},
 		{0, "dumb.up.sql"},
 		{2, "2.up.sql"},
+		{20261017120000, "20261017120000_test.up.sql"},
 		{0, ""},
 	}
 
@@ -67,7 +106,7 @@ func TestGetFileContent(t *testing.T) {
 	fileContent := []byte("CREATE TABLE test(name VARCHAR)")
 	os.WriteFile(testDirname+"/test.txt", fileContent, fs.FileMode(os.O_APPEND))
 
-	bytes, err := getFileContent(testDirname, "test.txt")
+	bytes, err := getFileContent(os.DirFS(testDirname), ".", "test.txt")
 	if err != nil {
 		t.Error(err)
 	}
@@ -83,7 +122,7 @@ func TestReadDir(t *testing.T) {
 
 	os.Create(testDirname + "/test.txt")
 
-	files, err := readDir(testDirname)
+	files, err := readDir(os.DirFS(testDirname), ".")
 	if err != nil {
 		t.Error(err)
 	}
@@ -96,11 +135,11 @@ func TestReadDir(t *testing.T) {
 func TestGetMigrationVersion(t *testing.T) {
 	db, mock := newSQlMock(t)
 
-	expectedVersion := 2
-	rows := mock.NewRows([]string{"version"}).AddRow(expectedVersion)
-	mock.ExpectQuery(SELECT_VERSION).WillReturnRows(rows)
+	expectedVersion := int64(2)
+	rows := mock.NewRows([]string{"version", "dirty"}).AddRow(expectedVersion, true)
+	mock.ExpectQuery(regexp.QuoteMeta(testDialect.SelectVersion(TABLE_NAME))).WillReturnRows(rows)
 
-	version, err := getMigrationVersion(db)
+	version, dirty, err := getMigrationVersion(context.Background(), db, testDialect, TABLE_NAME)
 	if err != nil {
 		t.Error(err)
 	}
@@ -108,4 +147,63 @@ func TestGetMigrationVersion(t *testing.T) {
 	if version != expectedVersion {
 		t.Errorf("expected version %d, got %d", expectedVersion, version)
 	}
+
+	if !dirty {
+		t.Error("expected dirty flag")
+	}
+}
+
+func TestGetCleanMigrationVersion(t *testing.T) {
+	db, mock := newSQlMock(t)
+
+	rows := mock.NewRows([]string{"version", "dirty"}).AddRow(3, true)
+	mock.ExpectQuery(regexp.QuoteMeta(testDialect.SelectVersion(TABLE_NAME))).WillReturnRows(rows)
+
+	_, err := getCleanMigrationVersion(context.Background(), db, testDialect, TABLE_NAME)
+	if !errors.Is(err, ErrDirty) {
+		t.Errorf("expected ErrDirty, got %v", err)
+	}
+}
+
+func TestTableExists(t *testing.T) {
+	db, mock := newSQlMock(t)
+
+	expectTableExists(mock, TABLE_NAME, true)
+	expectTableExists(mock, HISTORY_TABLE_NAME, false)
+
+	exists, err := tableExists(context.Background(), db, testDialect, TABLE_NAME)
+	if err != nil {
+		t.Error(err)
+	}
+	if !exists {
+		t.Errorf("expected table %q to exist", TABLE_NAME)
+	}
+
+	exists, err = tableExists(context.Background(), db, testDialect, HISTORY_TABLE_NAME)
+	if err != nil {
+		t.Error(err)
+	}
+	if exists {
+		t.Errorf("expected table %q to not exist", HISTORY_TABLE_NAME)
+	}
+}
+
+func TestHasDirective(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected bool
+	}{
+		{"-- pms:no-transaction\nCREATE INDEX CONCURRENTLY idx ON users (name);", true},
+		{"\n  --pms:no-transaction  \nVACUUM;", true},
+		{"-- create index\n-- pms:no-transaction\nVACUUM;", true},
+		{"VACUUM;\n-- pms:no-transaction", false},
+		{"-- pms:no-transactions\nVACUUM;", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if got := hasDirective([]byte(test.content), DIRECTIVE_NO_TRANSACTION); got != test.expected {
+			t.Errorf("content %q: got %t, expected %t", test.content, got, test.expected)
+		}
+	}
 }
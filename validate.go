@@ -0,0 +1,120 @@
+package pms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Applied migration which content differs from the file
+type ChecksumMismatch struct {
+	Version int64
+	Name    string
+	// Path of the `up` file or name of Go migration. Empty if not found.
+	File string
+	// Checksum stored in history table
+	Expected string
+	// Checksum of the file content. Empty if file not found.
+	Actual string
+}
+
+func (c ChecksumMismatch) String() string {
+	if c.File == "" {
+		return fmt.Sprintf("version %d %q: file not found, applied checksum %s", c.Version, c.Name, c.Expected)
+	}
+	return fmt.Sprintf("version %d %q: applied checksum %s, file checksum %s", c.Version, c.File, c.Expected, c.Actual)
+}
+
+// Returned by Validate if at least one applied migration was changed
+type ChecksumError struct {
+	Mismatches []ChecksumMismatch
+}
+
+func (e *ChecksumError) Error() string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("checksum validation failed for %d applied migration(s):", len(e.Mismatches)))
+	for _, m := range e.Mismatches {
+		s.WriteString("\n  - ")
+		s.WriteString(m.String())
+	}
+	return s.String()
+}
+
+// Check migrations directory like ValidateFS and compare checksums of
+// applied migrations with content of `up` files. Go migrations are
+// compared by name.
+//
+// Returns *DirectoryError with problems of directory and *ChecksumError
+// with all applied migrations which files were changed or removed after
+// they were applied. If both are found they are joined with errors.Join.
+func (m *Migration) Validate() error {
+	problems, err := validateFS(m.fsys, m.dir, m.source, m.goMigrations)
+	if err != nil {
+		return err
+	}
+	var dirErr error
+	if len(problems) != 0 {
+		dirErr = &DirectoryError{Dir: m.source, Problems: problems}
+	}
+
+	files, err := readDir(m.fsys, m.dir)
+	if err != nil {
+		return err
+	}
+	filesToRead, err := getFilesWithDirection(files, DIRECTION_UP)
+	if err != nil {
+		return err
+	}
+
+	applied, err := getAppliedMigrations(context.Background(), m.db, m.dialect, m.historyTable)
+	if err != nil {
+		return err
+	}
+
+	filesByVersion := make(map[int64]fs.DirEntry, len(filesToRead))
+	for _, file := range filesToRead {
+		filesByVersion[getVersionFromName(file.Name())] = file
+	}
+
+	var mismatches []ChecksumMismatch
+	for _, a := range applied {
+		mismatch := ChecksumMismatch{Version: a.version, Name: a.name, Expected: a.checksum}
+		if g, ok := m.goMigrations[a.version]; ok {
+			if sum := goMigrationChecksum(g.name); sum != a.checksum {
+				mismatch.File = goMigrationSource(a.version, g.name)
+				mismatch.Actual = sum
+				mismatches = append(mismatches, mismatch)
+			}
+			continue
+		}
+
+		file, ok := filesByVersion[a.version]
+		if !ok {
+			mismatches = append(mismatches, mismatch)
+			continue
+		}
+
+		content, err := getFileContent(m.fsys, m.dir, file.Name())
+		if err != nil {
+			return err
+		}
+		if sum := checksum(content); sum != a.checksum {
+			mismatch.File = path.Join(m.source, file.Name())
+			mismatch.Actual = sum
+			mismatches = append(mismatches, mismatch)
+		}
+	}
+
+	if len(mismatches) != 0 {
+		return errors.Join(dirErr, &ChecksumError{Mismatches: mismatches})
+	}
+	if dirErr != nil {
+		return dirErr
+	}
+
+	m.l.Info(fmt.Sprintf("Checksums of %d applied migration(s) are valid", len(applied)))
+	return nil
+}
@@ -0,0 +1,259 @@
+package pms
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Versions with this number of digits are timestamps and gaps between them are allowed
+const TIMESTAMP_VERSION_DIGITS = len(TIMESTAMP_FORMAT)
+
+// Problem of migrations directory found by ValidateFS
+type Problem struct {
+	// Path of file or directory
+	File string
+	// Line in file. 0 if problem isn't related to a line.
+	Line    int
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line != 0 {
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.File, p.Message)
+}
+
+// Returned by ValidateFS and ValidateDir with all problems of migrations directory
+type DirectoryError struct {
+	Dir      string
+	Problems []Problem
+}
+
+func (e *DirectoryError) Error() string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("validation of directory %q failed with %d problem(s):", e.Dir, len(e.Problems)))
+	for _, p := range e.Problems {
+		s.WriteString("\n  - ")
+		s.WriteString(p.String())
+	}
+	return s.String()
+}
+
+// Same as ValidateFS for the provided path of the OS filesystem.
+func ValidateDir(dirPath string) error {
+	dirPath = filepath.Clean(dirPath)
+	return validateSource(os.DirFS(dirPath), ".", filepath.ToSlash(dirPath))
+}
+
+// Check migration files in directory dir of fsys without database.
+//
+// Returns *DirectoryError with all found problems: malformed names,
+// files without .sql extension, duplicated versions, gaps between
+// sequential versions, missing `up` or `down` counterparts, empty
+// files and invalid UTF-8.
+func ValidateFS(fsys fs.FS, dir string) error {
+	return validateSource(fsys, dir, dir)
+}
+
+// source is the name of dir in errors
+func validateSource(fsys fs.FS, dir, source string) error {
+	problems, err := validateFS(fsys, dir, source, nil)
+	if err != nil {
+		return err
+	}
+	if len(problems) != 0 {
+		return &DirectoryError{Dir: source, Problems: problems}
+	}
+	return nil
+}
+
+// Files of single version
+type versionFiles struct {
+	up   []string
+	down []string
+}
+
+// Find problems of directory. goMigrations are versions of registered
+// Go migrations which fill gaps and can't be used by files.
+func validateFS(fsys fs.FS, dir, source string, goMigrations map[int64]goMigration) ([]Problem, error) {
+	files, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("directory %q not found. Error: %w", source, err)
+	}
+
+	var problems []Problem
+	report := func(fileName string, line int, format string, args ...any) {
+		problems = append(problems, Problem{
+			File:    path.Join(source, fileName),
+			Line:    line,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	byVersion := make(map[int64]*versionFiles)
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		fileName := file.Name()
+
+		version, direction, msg := parseFileName(fileName)
+		if msg != "" {
+			report(fileName, 0, msg)
+			continue
+		}
+
+		v, ok := byVersion[version]
+		if !ok {
+			v = &versionFiles{}
+			byVersion[version] = v
+		}
+		if direction == DIRECTION_UP {
+			v.up = append(v.up, fileName)
+		} else {
+			v.down = append(v.down, fileName)
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, fileName))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read file %q: %w", fileName, err)
+		}
+		if line := invalidUTF8Line(content); line != 0 {
+			report(fileName, line, "invalid UTF-8")
+		} else if isEmptySQL(content) {
+			report(fileName, 0, "file is empty")
+		}
+	}
+
+	versions := make([]int64, 0, len(byVersion)+len(goMigrations))
+	for version, v := range byVersion {
+		versions = append(versions, version)
+
+		if g, ok := goMigrations[version]; ok {
+			report(firstFile(v), 0, "version %d is used by go migration %q", version, g.name)
+			continue
+		}
+		if len(v.up) > 1 || len(v.down) > 1 || (len(v.up) == 1 && len(v.down) == 1 &&
+			getNameFromFileName(v.up[0]) != getNameFromFileName(v.down[0])) {
+			names := append(append([]string{}, v.up...), v.down...)
+			sort.Strings(names)
+			report(names[0], 0, "version %d is used by several migrations: %s", version, strings.Join(names, ", "))
+			continue
+		}
+		if len(v.down) == 0 {
+			report(v.up[0], 0, "down file is missing")
+		}
+		if len(v.up) == 0 {
+			report(v.down[0], 0, "up file is missing")
+		}
+	}
+	for version := range goMigrations {
+		if _, ok := byVersion[version]; !ok {
+			versions = append(versions, version)
+		}
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	if !hasTimestampVersion(versions) {
+		for i := 1; i < len(versions); i++ {
+			prev, next := versions[i-1], versions[i]
+			if next-prev == 2 {
+				problems = append(problems, Problem{File: dir, Message: fmt.Sprintf("version %d is missing between %d and %d", prev+1, prev, next)})
+			} else if next-prev > 2 {
+				problems = append(problems, Problem{File: dir, Message: fmt.Sprintf("versions %d-%d are missing between %d and %d", prev+1, next-1, prev, next)})
+			}
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].File != problems[j].File {
+			return problems[i].File < problems[j].File
+		}
+		return problems[i].Line < problems[j].Line
+	})
+
+	return problems, nil
+}
+
+// Parse file name by template `{version}_{name}.{up|down}.sql`.
+// Returns description of the problem if name is malformed.
+func parseFileName(fileName string) (int64, Direction, string) {
+	if path.Ext(fileName) != ".sql" {
+		return 0, "", "extension should be .sql"
+	}
+	chunks := strings.Split(fileName, ".")
+	if len(chunks) != 3 {
+		return 0, "", "name should match {version}_{name}.{up|down}.sql"
+	}
+
+	direction := Direction(chunks[1])
+	if direction != DIRECTION_UP && direction != DIRECTION_DOWN {
+		return 0, "", fmt.Sprintf("direction %q should be up or down", direction)
+	}
+
+	versionStr, name, found := strings.Cut(chunks[0], "_")
+	if !found || versionStr == "" || strings.Trim(versionStr, "0123456789") != "" {
+		return 0, "", "name should start with version followed by underscore"
+	}
+	version, err := strconv.ParseInt(versionStr, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Sprintf("version %q is too big", versionStr)
+	}
+	if version == 0 {
+		return 0, "", "version should be greater than 0"
+	}
+	if name == "" {
+		return 0, "", "name should not be empty"
+	}
+
+	return version, direction, ""
+}
+
+// Number of the first line with invalid UTF-8 or 0
+func invalidUTF8Line(content []byte) int {
+	if utf8.Valid(content) {
+		return 0
+	}
+	for i, line := range bytes.Split(content, []byte("\n")) {
+		if !utf8.Valid(line) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Content has only spaces and `--` comments
+func isEmptySQL(content []byte) bool {
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
+
+func hasTimestampVersion(versions []int64) bool {
+	for _, version := range versions {
+		if len(strconv.FormatInt(version, 10)) >= TIMESTAMP_VERSION_DIGITS {
+			return true
+		}
+	}
+	return false
+}
+
+func firstFile(v *versionFiles) string {
+	if len(v.up) != 0 {
+		return v.up[0]
+	}
+	return v.down[0]
+}
@@ -0,0 +1,119 @@
+package pms
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestValidateFS(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		if err := ValidateFS(newSQLiteMigrations(), "migrations"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("timestamp versions with gaps", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"migrations/20261017120000_users.up.sql":   {Data: []byte("CREATE TABLE users (id INT);")},
+			"migrations/20261017120000_users.down.sql": {Data: []byte("DROP TABLE users;")},
+			"migrations/20261018093000_posts.up.sql":   {Data: []byte("CREATE TABLE posts (id INT);")},
+			"migrations/20261018093000_posts.down.sql": {Data: []byte("DROP TABLE posts;")},
+		}
+		if err := ValidateFS(fsys, "migrations"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("all problems", func(t *testing.T) {
+		sql := []byte("SELECT 1;")
+		fsys := fstest.MapFS{
+			"migrations/1_users.up.sql":       {Data: sql},
+			"migrations/1_users.down.sql":     {Data: sql},
+			"migrations/abc.up.sql":           {Data: sql},
+			"migrations/2_a.sideways.sql":     {Data: sql},
+			"migrations/2_posts.up.sql":       {Data: sql},
+			"migrations/2_posts.down.sql":     {Data: []byte("  \n-- nothing to do\n")},
+			"migrations/3_tags.up.sql":        {Data: sql},
+			"migrations/3_labels.up.sql":      {Data: sql},
+			"migrations/4_comments.up.sql":    {Data: sql},
+			"migrations/5_likes.down.sql":     {Data: sql},
+			"migrations/8_broken.up.sql":      {Data: []byte("SELECT 1;\nSELECT '\xff';\n")},
+			"migrations/8_broken.down.sql":    {Data: sql},
+			"migrations/README.md":            {Data: []byte("# migrations")},
+			"migrations/0_zero.up.sql":        {Data: sql},
+			"migrations/9_.up.sql":            {Data: sql},
+			"migrations/nested/1_x.up.sql":    {Data: sql},
+			"migrations/10_users.up.sql.orig": {Data: sql},
+		}
+
+		err := ValidateFS(fsys, "migrations")
+		var dirErr *DirectoryError
+		if !errors.As(err, &dirErr) {
+			t.Fatalf("expected *DirectoryError, got %v", err)
+		}
+
+		expected := []Problem{
+			{File: "migrations", Message: "versions 6-7 are missing between 5 and 8"},
+			{File: "migrations/0_zero.up.sql", Message: "version should be greater than 0"},
+			{File: "migrations/10_users.up.sql.orig", Message: "extension should be .sql"},
+			{File: "migrations/2_a.sideways.sql", Message: `direction "sideways" should be up or down`},
+			{File: "migrations/2_posts.down.sql", Message: "file is empty"},
+			{File: "migrations/3_labels.up.sql", Message: "version 3 is used by several migrations: 3_labels.up.sql, 3_tags.up.sql"},
+			{File: "migrations/4_comments.up.sql", Message: "down file is missing"},
+			{File: "migrations/5_likes.down.sql", Message: "up file is missing"},
+			{File: "migrations/8_broken.up.sql", Line: 2, Message: "invalid UTF-8"},
+			{File: "migrations/9_.up.sql", Message: "name should not be empty"},
+			{File: "migrations/README.md", Message: "extension should be .sql"},
+			{File: "migrations/abc.up.sql", Message: "name should start with version followed by underscore"},
+		}
+		if fmt.Sprint(dirErr.Problems) != fmt.Sprint(expected) {
+			t.Errorf("got problems:\n%v\nexpected:\n%v", dirErr.Problems, expected)
+		}
+	})
+
+	t.Run("directory not found", func(t *testing.T) {
+		err := ValidateFS(fstest.MapFS{}, "migrations")
+		var dirErr *DirectoryError
+		if err == nil || errors.As(err, &dirErr) {
+			t.Errorf("expected error of reading directory, got %v", err)
+		}
+	})
+}
+
+func TestValidateDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "1_users.up.sql"), []byte("CREATE TABLE users (id INT);"), 0644)
+
+	err := ValidateDir(dir)
+	var dirErr *DirectoryError
+	if !errors.As(err, &dirErr) || len(dirErr.Problems) != 1 {
+		t.Fatalf("expected missing down file, got %v", err)
+	}
+	expected := filepath.ToSlash(dir) + "/1_users.up.sql: down file is missing"
+	if dirErr.Problems[0].String() != expected {
+		t.Errorf("got %q, expected %q", dirErr.Problems[0].String(), expected)
+	}
+
+	t.Run("parent path", func(t *testing.T) {
+		if err := os.Mkdir(filepath.Join(dir, "sub"), 0777); err != nil {
+			t.Fatal(err)
+		}
+		wd, err := os.Getwd()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chdir(filepath.Join(dir, "sub")); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chdir(wd)
+
+		err = ValidateDir("..")
+		if !errors.As(err, &dirErr) || dirErr.Problems[0].String() != "../1_users.up.sql: down file is missing" {
+			t.Errorf("expected missing down file, got %v", err)
+		}
+	})
+}
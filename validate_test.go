@@ -0,0 +1,78 @@
+package pms
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMigrationValidate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/1_users.up.sql":   {Data: []byte("CREATE TABLE users(id SERIAL)")},
+		"migrations/1_users.down.sql": {Data: []byte("DROP TABLE users")},
+		"migrations/2_posts.up.sql":   {Data: []byte("CREATE TABLE posts(id SERIAL, title TEXT)")},
+		"migrations/2_posts.down.sql": {Data: []byte("DROP TABLE posts")},
+	}
+	historyColumns := []string{"version", "name", "direction", "checksum", "applied_at"}
+	appliedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	newMigration := func(t *testing.T) (Migrator, sqlmock.Sqlmock) {
+		t.Helper()
+		db, mock := newSQlMock(t)
+		t.Cleanup(func() { db.Close() })
+
+		expectNew(mock)
+
+		m, err := NewFromFS(db, fsys, "migrations")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m, mock
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		m, mock := newMigration(t)
+		rows := mock.NewRows(historyColumns).
+			AddRow(1, "users", "up", checksum(fsys["migrations/1_users.up.sql"].Data), appliedAt).
+			AddRow(2, "posts", "up", "changed", appliedAt).
+			AddRow(2, "posts", "down", checksum(fsys["migrations/2_posts.down.sql"].Data), appliedAt)
+		mock.ExpectQuery(regexp.QuoteMeta(selectHistoryQuery(testDialect, HISTORY_TABLE_NAME))).WillReturnRows(rows)
+
+		if err := m.Validate(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("changed and removed files", func(t *testing.T) {
+		m, mock := newMigration(t)
+		rows := mock.NewRows(historyColumns).
+			AddRow(1, "users", "up", checksum(fsys["migrations/1_users.up.sql"].Data), appliedAt).
+			AddRow(2, "posts", "up", checksum([]byte("CREATE TABLE posts(id SERIAL)")), appliedAt).
+			AddRow(3, "comments", "up", "removed", appliedAt)
+		mock.ExpectQuery(regexp.QuoteMeta(selectHistoryQuery(testDialect, HISTORY_TABLE_NAME))).WillReturnRows(rows)
+
+		err := m.Validate()
+		var checksumErr *ChecksumError
+		if !errors.As(err, &checksumErr) {
+			t.Fatalf("expected *ChecksumError, got %v", err)
+		}
+		expected := []ChecksumMismatch{
+			{
+				Version:  2,
+				Name:     "posts",
+				File:     "migrations/2_posts.up.sql",
+				Expected: checksum([]byte("CREATE TABLE posts(id SERIAL)")),
+				Actual:   checksum(fsys["migrations/2_posts.up.sql"].Data),
+			},
+			{Version: 3, Name: "comments", Expected: "removed"},
+		}
+		if fmt.Sprint(checksumErr.Mismatches) != fmt.Sprint(expected) {
+			t.Errorf("got mismatches %v, expected %v", checksumErr.Mismatches, expected)
+		}
+	})
+}